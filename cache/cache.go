@@ -0,0 +1,124 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+// Package cache implements a size-bounded in-memory cache with least recently
+// used eviction.
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache represents a cache of byte slices, bounded by the total size of the
+// cached byte slices. It is safe for concurrent use.
+type Cache struct {
+	capacity int64
+	entries  map[string]*list.Element
+	hits     uint64
+	lru      *list.List // Most recently used entries at the front.
+	misses   uint64
+	mu       sync.Mutex
+	size     int64
+}
+
+// Stats represents the statistics of a cache.
+type Stats struct {
+	Capacity int64  `json:"capacity"` // Capacity, in bytes.
+	Hits     uint64 `json:"hits"`     // Number of keys found by Get.
+	Len      int    `json:"len"`      // Number of entries.
+	Misses   uint64 `json:"misses"`   // Number of keys not found by Get.
+	Size     int64  `json:"size"`     // Total size of the values, in bytes.
+}
+
+type entry struct {
+	key   string
+	value []byte
+}
+
+// Clear removes all the entries from the cache. The hits and misses counters
+// are left unchanged.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+}
+
+// Get returns the value associated with the given key and reports whether
+// the key is present in the cache.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.hits++
+		c.lru.MoveToFront(e)
+		return e.Value.(*entry).value, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// Len returns the number of entries in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Put associates the given value with the given key, evicting the least
+// recently used entries if the capacity of the cache is exceeded. Values
+// larger than the capacity of the cache are not cached.
+func (c *Cache) Put(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	if int64(len(value)) > c.capacity {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key, value})
+	c.size += int64(len(value))
+	for c.size > c.capacity {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Size returns the total size of the cached values.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Stats returns the statistics of the cache.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Capacity: c.capacity,
+		Hits:     c.hits,
+		Len:      c.lru.Len(),
+		Misses:   c.misses,
+		Size:     c.size,
+	}
+}
+
+func (c *Cache) remove(e *list.Element) {
+	en := c.lru.Remove(e).(*entry)
+	delete(c.entries, en.key)
+	c.size -= int64(len(en.value))
+}
+
+// New creates and returns a new (empty) cache with the given capacity,
+// in bytes.
+func New(capacity int64) *Cache {
+	return &Cache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/noll/mjau/test"
+)
+
+func TestCacheGet(t *testing.T) {
+	cache := New(8)
+	_, ok := cache.Get("one")
+	test.Verify(t, 1, 0, false, ok)
+
+	cache.Put("one", []byte("1"))
+	value, ok := cache.Get("one")
+	test.VerifyFatal(t, 2, 0, true, ok)
+	test.Verify(t, 3, 0, "1", string(value))
+}
+
+func TestCachePut(t *testing.T) {
+	cache := New(7)
+	cache.Put("one", []byte("111"))
+	cache.Put("two", []byte("222"))
+	test.Verify(t, 1, 0, 2, cache.Len())
+	test.Verify(t, 2, 0, int64(6), cache.Size())
+
+	// Replace an entry.
+	cache.Put("two", []byte("22"))
+	test.Verify(t, 3, 0, 2, cache.Len())
+	test.Verify(t, 4, 0, int64(5), cache.Size())
+
+	// Make "one" the most recently used entry,
+	// then evict the least recently used entry.
+	cache.Get("one")
+	cache.Put("three", []byte("333"))
+	test.Verify(t, 5, 0, 2, cache.Len())
+	test.Verify(t, 6, 0, int64(6), cache.Size())
+	_, ok := cache.Get("two")
+	test.Verify(t, 7, 0, false, ok)
+	_, ok = cache.Get("one")
+	test.Verify(t, 8, 0, true, ok)
+
+	// Values larger than the capacity are not cached.
+	cache.Put("four", []byte("444444444"))
+	test.Verify(t, 9, 0, 2, cache.Len())
+	_, ok = cache.Get("four")
+	test.Verify(t, 10, 0, false, ok)
+}
+
+func TestCacheClear(t *testing.T) {
+	cache := New(8)
+	cache.Put("one", []byte("1"))
+	cache.Put("two", []byte("2"))
+	cache.Clear()
+	test.Verify(t, 1, 0, 0, cache.Len())
+	test.Verify(t, 2, 0, int64(0), cache.Size())
+	_, ok := cache.Get("one")
+	test.Verify(t, 3, 0, false, ok)
+
+	// The cache is still usable.
+	cache.Put("three", []byte("3"))
+	_, ok = cache.Get("three")
+	test.Verify(t, 4, 0, true, ok)
+}
+
+func TestCacheStats(t *testing.T) {
+	cache := New(8)
+	cache.Put("one", []byte("111"))
+	cache.Get("one")
+	cache.Get("one")
+	cache.Get("two")
+	want := Stats{Capacity: 8, Hits: 2, Len: 1, Misses: 1, Size: 3}
+	test.Verify(t, 1, 0, want, cache.Stats())
+
+	// Clearing the cache keeps the counters.
+	cache.Clear()
+	want = Stats{Capacity: 8, Hits: 2, Misses: 1}
+	test.Verify(t, 2, 0, want, cache.Stats())
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	ihttp "github.com/noll/mjau/http" // Internal http package.
+	"github.com/noll/mjau/inventory"
+)
+
+// Check runs the same loaders as the server on the font library, the
+// whitelist, and the templates, and prints every problem found to standard
+// error. Exits the program signaling abnormal termination if any problem was
+// found.
+func Check() {
+	var errs []error
+	// Check font library.
+	fontInventory := inventory.New()
+	if err := fontInventory.Build(*lFlag); err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, fontInventory.Errors...)
+		if fontInventory.Len() == 0 {
+			errs = append(errs, fmt.Errorf("%s: empty font library", *lFlag))
+		}
+	}
+	// Check whitelist.
+	if _, err := ReadWhitelist(); err != nil {
+		errs = append(errs, err)
+	}
+	// Check base URL of linked font files.
+	if _, err := FontPath(*uFlag); err != nil {
+		errs = append(errs, err)
+	}
+	// Check templates.
+	if templates, err := ReadTemplates(*tFlag); err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, ihttp.CheckTemplates(templates)...)
+	}
+	for _, err := range errs {
+		PrintError(err.Error())
+	}
+	if len(errs) > 0 {
+		PrintErrorExit(fmt.Sprintf("check: %d problem(s) found", len(errs)))
+	}
+	fmt.Println(ProgName + ": check: no problems found")
+}
@@ -16,6 +16,11 @@ func (e Error) Error() string {
 	return ProgName + ": " + string(e)
 }
 
+// PrintError prints the given error message to standard error.
+func PrintError(message string) {
+	fmt.Fprintln(os.Stderr, Error(message))
+}
+
 // PrintErrorExit prints the given error message to standard error
 // and exits the program signaling abnormal termination.
 func PrintErrorExit(message string) {
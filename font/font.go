@@ -6,9 +6,16 @@
 package font
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,15 +27,40 @@ const (
 	// Supported font file formats.
 	EOT
 	WOFF
+	WOFF2
+	TTF
+	OTF
 )
 
-// Font represents a single font file.
+// Formats holds all the supported font file formats, in the order in which
+// they are listed in the src descriptor of @font-face CSS rules.
+var Formats = []Format{EOT, WOFF2, WOFF, TTF, OTF}
+
+// Axis represents a design variation axis of a variable font, identified by
+// its OpenType tag, such as wght, wdth, or slnt.
+type Axis struct {
+	Tag     string
+	Min     float64
+	Default float64
+	Max     float64
+}
+
+// Font represents a single font file. A font may be split into subsets, each
+// of them covering a Unicode range, which are fonts themselves. Variable fonts
+// have design variation axes, the weight is then the default one.
 type Font struct {
+	Axes   []Axis
 	Family string
 	Format Format
-	Path   string
-	Style  string
-	Weight int
+	// Generate reports whether the subset is generated from the font
+	// file by subsetting it, instead of being a separate font file.
+	Generate     bool
+	Path         string
+	Style        string
+	SubsetName   string
+	Subsets      []*Font
+	UnicodeRange string
+	Weight       int
 }
 
 // Format represents the format of a font file.
@@ -43,12 +75,24 @@ type Metadata struct {
 
 // Subfamily represents the metadata of a font subfamily.
 type Subfamily struct {
+	Axes     []Axis
 	Basename string
 	Formats  []string
 	Style    string
+	Subsets  []Subset
 	Weight   int
 }
 
+// Subset represents the metadata of a named subset of a font subfamily. The
+// subset is either a separate font file, identified by its basename, or it is
+// generated from the font files of the subfamily. The Unicode range defaults
+// to the one of the built-in subset with the same name.
+type Subset struct {
+	Basename     string
+	Name         string
+	UnicodeRange string
+}
+
 // Contents reads and returns the contents of the font file.
 // Returns an error if the font file cannot be read.
 func (f *Font) Contents() ([]byte, error) {
@@ -66,11 +110,48 @@ func (f *Font) MimeType() string {
 		return "application/vnd.ms-fontobject"
 	case WOFF:
 		return "application/x-font-woff"
+	case WOFF2:
+		return "font/woff2"
+	case TTF:
+		return "font/ttf"
+	case OTF:
+		return "font/otf"
 	}
 	// Should not happen.
 	return ""
 }
 
+// Axis returns the design variation axis of the font with the given tag,
+// or nil if the font has no such axis.
+func (f *Font) Axis(tag string) *Axis {
+	for i := range f.Axes {
+		if f.Axes[i].Tag == tag {
+			return &f.Axes[i]
+		}
+	}
+	return nil
+}
+
+// WeightRange returns the range of weights covered by the font, which is
+// the range of the wght axis of variable fonts.
+func (f *Font) WeightRange() (min, max int) {
+	if a := f.Axis("wght"); a != nil {
+		return int(a.Min), int(a.Max)
+	}
+	return f.Weight, f.Weight
+}
+
+// SubsetFont returns the subset of the font with the given name,
+// or nil if the font has no such subset.
+func (f *Font) SubsetFont(name string) *Font {
+	for _, subset := range f.Subsets {
+		if subset.SubsetName == name {
+			return subset
+		}
+	}
+	return nil
+}
+
 // ModTime returns the modification time of the font.
 // If there is an error, it will be of type *os.PathError.
 func (f *Font) ModTime() (time.Time, error) {
@@ -81,12 +162,87 @@ func (f *Font) ModTime() (time.Time, error) {
 	return fi.ModTime(), nil
 }
 
+// Validate checks that the font file exists and can be read, and that its
+// magic bytes match the font format. The subsets are not checked. Returns an
+// error describing why the font file is not valid.
+func (f *Font) Validate() error {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s: is a directory", f.Path)
+	}
+	// The EOT header is the longest
+	// header which needs to be checked.
+	header := make([]byte, 36)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if !f.Format.Match(header[:n], fi.Size()) {
+		return fmt.Errorf("%s: not a valid %s font file", f.Path,
+			strings.ToUpper(f.Format.String()))
+	}
+	return nil
+}
+
+// CssFormat returns the format hint used in the src descriptor of @font-face
+// CSS rules for the font format.
+// Calling the method on NOF returns the empty string.
+func (f *Format) CssFormat() string {
+	switch *f {
+	case EOT:
+		return "embedded-opentype"
+	case WOFF:
+		return "woff"
+	case WOFF2:
+		return "woff2"
+	case TTF:
+		return "truetype"
+	case OTF:
+		return "opentype"
+	}
+	return ""
+}
+
 // Equal reports whether the value pointed to by f and
 // the v value are the one and same font format.
 func (f *Format) Equal(v Format) bool {
 	return *f == v
 }
 
+// Match reports whether the given header, the first bytes of a font file
+// of the given size, matches the font format: wOFF for WOFF, wOF2 for WOFF2,
+// the 1.0, true, or OTTO sfnt versions for TTF and OTF, and the magic number
+// and the font file size found in the EOT header for EOT.
+func (f *Format) Match(header []byte, size int64) bool {
+	has := func(magic string) bool {
+		return bytes.HasPrefix(header, []byte(magic))
+	}
+	switch *f {
+	case EOT:
+		if len(header) < 36 {
+			return false
+		}
+		eotSize := binary.LittleEndian.Uint32(header)
+		magic := binary.LittleEndian.Uint16(header[34:])
+		return int64(eotSize) == size && magic == 0x504c
+	case WOFF:
+		return has("wOFF")
+	case WOFF2:
+		return has("wOF2")
+	case TTF, OTF:
+		return has("\x00\x01\x00\x00") || has("true") || has("OTTO")
+	}
+	return false
+}
+
 // FontFormat determines f to point to the font format corresponding to the
 // given font format string representation. If the given font format string
 // representation is not valid, f remains unchanged.
@@ -96,6 +252,12 @@ func (f *Format) FromString(format string) {
 		*f = EOT
 	case "woff":
 		*f = WOFF
+	case "woff2":
+		*f = WOFF2
+	case "ttf":
+		*f = TTF
+	case "otf":
+		*f = OTF
 	}
 }
 
@@ -107,38 +269,265 @@ func (f *Format) String() string {
 		return "eot"
 	case WOFF:
 		return "woff"
+	case WOFF2:
+		return "woff2"
+	case TTF:
+		return "ttf"
+	case OTF:
+		return "otf"
 	}
 	return ""
 }
 
+// Check checks the metadata and returns the problems found, such as unknown
+// font formats, subfamilies sharing the same weight and style, and invalid
+// subsets. Each problem is reported with the metadata file and the field in
+// which it was found. Must be used only after reading the metadata from a
+// JSON-encoded file or deriving it from the font files.
+func (m *Metadata) Check() (errs []error) {
+	report := func(field string, format string, a ...interface{}) {
+		err := fmt.Errorf("%s: %s: %s", m.path, field, fmt.Sprintf(format, a...))
+		errs = append(errs, err)
+	}
+	seen := make(map[string]int)
+	for i, s := range m.Subfamilies {
+		field := fmt.Sprintf("subfamilies[%d]", i)
+		if s.Basename == "" {
+			report(field+".basename", "missing basename")
+		}
+		for j, f := range s.Formats {
+			format := NOF
+			format.FromString(f)
+			if format == NOF {
+				report(fmt.Sprintf("%s.formats[%d]", field, j),
+					"unknown font format %q", f)
+			}
+		}
+		key := strconv.Itoa(s.Weight) + s.Style
+		if k, ok := seen[key]; ok {
+			report(field, "same weight and style as subfamilies[%d]", k)
+		} else {
+			seen[key] = i
+		}
+		for j, subset := range s.Subsets {
+			sField := fmt.Sprintf("%s.subsets[%d]", field, j)
+			unicodeRange := subset.UnicodeRange
+			if unicodeRange == "" {
+				unicodeRange = UnicodeRanges[subset.Name]
+			}
+			switch {
+			case subset.Name == "":
+				report(sField+".name", "missing subset name")
+			case unicodeRange == "":
+				report(sField+".unicodeRange",
+					"unknown subset %q without Unicode range", subset.Name)
+			default:
+				if _, err := ParseUnicodeRange(unicodeRange); err != nil {
+					report(sField+".unicodeRange", "%s", err)
+				}
+			}
+		}
+	}
+	return
+}
+
 // Fonts returns all the valid fonts corresponding to the metadata.
-// Must be used only after reading the metadata from a JSON-encoded file.
+// Must be used only after reading the metadata from a JSON-encoded file
+// or deriving it from the font files.
 func (m *Metadata) Fonts() (fonts []*Font) {
 	for _, s := range m.Subfamilies {
 		for _, f := range s.Formats {
 			format := NOF
 			format.FromString(f)
 			if format == NOF {
-				// Unsupported font file format, skip it.
-				// TODO: Add logging.
+				// Unsupported font file format, as reported
+				// by Check, skip it.
 				continue
 			}
 			dir := filepath.Dir(m.path)
 			filename := s.Basename + "." + f
 			path := filepath.Join(dir, filename)
 			font := &Font{
+				Axes:   s.Axes,
 				Family: m.Family,
 				Format: format,
 				Path:   path,
 				Style:  s.Style,
 				Weight: s.Weight,
 			}
+			if a := font.Axis("wght"); a != nil && font.Weight == 0 {
+				// Default the weight of a variable font to the
+				// default value of its wght axis, or to the
+				// closest value to 400 if it has none.
+				weight := a.Default
+				if weight < a.Min || weight > a.Max {
+					weight = math.Max(a.Min, math.Min(a.Max, 400))
+				}
+				font.Weight = int(weight)
+			}
+			font.Subsets = m.subsets(s, font)
 			fonts = append(fonts, font)
 		}
 	}
 	return
 }
 
+// subsets returns the valid subsets of the given font of the given subfamily.
+// Subsets without a separate font file are generated only from the font
+// formats supported by the subsetter.
+func (m *Metadata) subsets(s Subfamily, f *Font) (subsets []*Font) {
+	for _, subset := range s.Subsets {
+		unicodeRange := subset.UnicodeRange
+		if unicodeRange == "" {
+			unicodeRange = UnicodeRanges[subset.Name]
+		}
+		if subset.Name == "" || unicodeRange == "" {
+			// Unnamed subset or unknown Unicode range, as
+			// reported by Check, skip it.
+			continue
+		}
+		if _, err := ParseUnicodeRange(unicodeRange); err != nil {
+			// Invalid Unicode range, as reported by Check,
+			// skip subset.
+			continue
+		}
+		font := *f
+		font.Subsets = nil
+		font.SubsetName = subset.Name
+		font.UnicodeRange = unicodeRange
+		if subset.Basename != "" {
+			filename := subset.Basename + "." + f.Format.String()
+			font.Path = filepath.Join(filepath.Dir(m.path), filename)
+		} else if f.Format == TTF || f.Format == OTF || f.Format == WOFF {
+			font.Generate = true
+		} else {
+			// The subset can't be generated, skip it.
+			continue
+		}
+		subsets = append(subsets, &font)
+	}
+	return
+}
+
+// Merge completes the metadata using the given metadata, usually derived from
+// the font files by Scan. The values already present in the metadata take
+// precedence, only the family name and the subfamily fields which are not set
+// are taken from the given metadata. Subfamilies are matched by basename. If
+// the metadata has no subfamilies, all the given subfamilies are taken.
+func (m *Metadata) Merge(d *Metadata) {
+	if m.Family == "" {
+		m.Family = d.Family
+	}
+	if m.path == "" {
+		m.path = d.path
+	}
+	if len(m.Subfamilies) == 0 {
+		m.Subfamilies = append(m.Subfamilies, d.Subfamilies...)
+		return
+	}
+	for i := range m.Subfamilies {
+		s := &m.Subfamilies[i]
+		for _, ds := range d.Subfamilies {
+			if s.Basename != ds.Basename {
+				continue
+			}
+			if len(s.Formats) == 0 {
+				s.Formats = ds.Formats
+			}
+			if s.Style == "" {
+				s.Style = ds.Style
+			}
+			if s.Weight == 0 {
+				s.Weight = ds.Weight
+			}
+			if len(s.Subsets) == 0 {
+				s.Subsets = ds.Subsets
+			}
+			if len(s.Axes) == 0 {
+				s.Axes = ds.Axes
+			}
+		}
+	}
+}
+
+// ErrNoFontFiles is returned when scanning a directory containing no font
+// files, as opposed to font files which cannot be parsed.
+var ErrNoFontFiles = errors.New("no font files")
+
+// Scan derives the metadata from the font files of the named directory, using
+// the font information read from the TTF, OTF, and WOFF font files. Font files
+// sharing the same basename are considered to be one subfamily available in
+// several font formats, so EOT and WOFF2 font files are included only if they
+// are accompanied by a font file which can be parsed. Returns an error if the
+// named directory cannot be read or if it contains no such font file, which is
+// ErrNoFontFiles if it contains no font file at all.
+func (m *Metadata) Scan(name string) error {
+	entries, err := ioutil.ReadDir(name)
+	if err != nil {
+		return err
+	}
+	var basenames []string
+	formats := make(map[string][]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		format := NOF
+		format.FromString(strings.TrimPrefix(ext, "."))
+		if format == NOF {
+			continue
+		}
+		basename := strings.TrimSuffix(entry.Name(), ext)
+		if _, ok := formats[basename]; !ok {
+			basenames = append(basenames, basename)
+		}
+		formats[basename] = append(formats[basename], ext[1:])
+	}
+	if len(basenames) == 0 {
+		return ErrNoFontFiles
+	}
+	for _, basename := range basenames {
+		var info *Info
+		for _, f := range formats[basename] {
+			format := NOF
+			format.FromString(f)
+			if format != TTF && format != OTF && format != WOFF {
+				continue
+			}
+			path := filepath.Join(name, basename+"."+f)
+			if info, err = ReadInfo(path); err == nil {
+				break
+			}
+			// Unreadable font file, try the next format.
+		}
+		if info == nil {
+			// No font information, skip subfamily.
+			continue
+		}
+		if m.Family == "" {
+			m.Family = info.Family
+		}
+		style := "normal"
+		if info.Italic {
+			style = "italic"
+		}
+		subfamily := Subfamily{
+			Axes:     info.Axes,
+			Basename: basename,
+			Formats:  formats[basename],
+			Style:    style,
+			Weight:   info.Weight,
+		}
+		m.Subfamilies = append(m.Subfamilies, subfamily)
+	}
+	if len(m.Subfamilies) == 0 {
+		return fmt.Errorf("%s: no font information", name)
+	}
+	m.path = filepath.Join(name, "metadata.json")
+	return nil
+}
+
 // Read reads and parses the JSON-encoded contents of the named metadata file.
 // Returns an error if the named file cannot be read or correctly parsed.
 func (m *Metadata) Read(name string) error {
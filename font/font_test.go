@@ -45,6 +45,21 @@ func TestFontMimeType(t *testing.T) {
 	gMimeType := font.MimeType()
 	wMimeType := "application/x-font-woff"
 	test.Verify(t, 1, 0, wMimeType, gMimeType)
+
+	font.Format = WOFF2
+	gMimeType = font.MimeType()
+	wMimeType = "font/woff2"
+	test.Verify(t, 2, 0, wMimeType, gMimeType)
+
+	font.Format = TTF
+	gMimeType = font.MimeType()
+	wMimeType = "font/ttf"
+	test.Verify(t, 3, 0, wMimeType, gMimeType)
+
+	font.Format = OTF
+	gMimeType = font.MimeType()
+	wMimeType = "font/otf"
+	test.Verify(t, 4, 0, wMimeType, gMimeType)
 }
 
 func TestFontModTime(t *testing.T) {
@@ -63,6 +78,31 @@ func TestFontModTime(t *testing.T) {
 	test.Verify(t, 3, 0, true, gModTime.Equal(wModTime))
 }
 
+func TestFormatCssFormat(t *testing.T) {
+	var cases = []struct {
+		Format    Format
+		CssFormat string
+	}{
+		// Case 1
+		{EOT, "embedded-opentype"},
+		// Case 2
+		{WOFF, "woff"},
+		// Case 3
+		{WOFF2, "woff2"},
+		// Case 4
+		{TTF, "truetype"},
+		// Case 5
+		{OTF, "opentype"},
+		// Case 6
+		{NOF, ""},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		test.Verify(t, 1, j, c.CssFormat, c.Format.CssFormat())
+	}
+}
+
 func TestFormatEqual(t *testing.T) {
 	format := EOT
 	test.Verify(t, 1, 0, true, format.Equal(EOT))
@@ -75,13 +115,154 @@ func TestFormatFromString(t *testing.T) {
 	test.Verify(t, 1, 0, EOT, format)
 	format.FromString("WOFF")
 	test.Verify(t, 2, 0, WOFF, format)
+	format.FromString("woff2")
+	test.Verify(t, 3, 0, WOFF2, format)
+	format.FromString("TTF")
+	test.Verify(t, 4, 0, TTF, format)
+	format.FromString("otf")
+	test.Verify(t, 5, 0, OTF, format)
+	format.FromString("nonexistent")
+	test.Verify(t, 6, 0, OTF, format)
 }
 
 func TestFormatString(t *testing.T) {
 	format := EOT
 	test.Verify(t, 1, 0, "eot", format.String())
+	format = WOFF2
+	test.Verify(t, 2, 0, "woff2", format.String())
+	format = TTF
+	test.Verify(t, 3, 0, "ttf", format.String())
+	format = OTF
+	test.Verify(t, 4, 0, "otf", format.String())
 	format = NOF
-	test.Verify(t, 2, 0, "", format.String())
+	test.Verify(t, 5, 0, "", format.String())
+}
+
+func TestFontValidate(t *testing.T) {
+	var cases = []struct {
+		Font  *Font
+		Valid bool
+	}{
+		// Case 1
+		{&Font{Format: WOFF, Path: filepath.Join(amf, "amaranth-regular.woff")}, true},
+		// Case 2
+		{&Font{Format: EOT, Path: filepath.Join(amf, "amaranth-regular.eot")}, true},
+		// Case 3
+		{&Font{Format: TTF, Path: filepath.Join(amf, "amaranth-regular.woff")}, false},
+		// Case 4
+		{&Font{Format: EOT, Path: filepath.Join(amf, "amaranth-regular.woff")}, false},
+		// Case 5
+		{&Font{Format: WOFF, Path: filepath.Join(amf, "nonexistent.woff")}, false},
+		// Case 6
+		{&Font{Format: WOFF, Path: amf}, false},
+		// Case 7
+		{&Font{Format: WOFF, Path: filepath.Join(amf, "metadata.json")}, false},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		err := c.Font.Validate()
+		test.Verify(t, 1, j, c.Valid, nil == err)
+	}
+}
+
+func TestFormatMatch(t *testing.T) {
+	eot := make([]byte, 36)
+	eot[0] = 36
+	eot[34], eot[35] = 'L', 'P'
+	var cases = []struct {
+		Format Format
+		Header []byte
+		Size   int64
+		Match  bool
+	}{
+		// Case 1
+		{WOFF, []byte("wOFF\x00\x01"), 1024, true},
+		// Case 2
+		{WOFF, []byte("wOF2\x00\x01"), 1024, false},
+		// Case 3
+		{WOFF2, []byte("wOF2\x00\x01"), 1024, true},
+		// Case 4
+		{TTF, []byte("\x00\x01\x00\x00\x00\x10"), 1024, true},
+		// Case 5
+		{TTF, []byte("true\x00\x10"), 1024, true},
+		// Case 6
+		{OTF, []byte("OTTO\x00\x10"), 1024, true},
+		// Case 7
+		{OTF, []byte("wOFF\x00\x01"), 1024, false},
+		// Case 8
+		{EOT, eot, 36, true},
+		// Case 9
+		{EOT, eot, 1024, false},
+		// Case 10
+		{EOT, eot[:34], 36, false},
+		// Case 11
+		{NOF, []byte("wOFF"), 1024, false},
+		// Case 12
+		{WOFF, []byte("wOF"), 3, false},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		test.Verify(t, 1, j, c.Match, c.Format.Match(c.Header, c.Size))
+	}
+}
+
+func TestMetadataCheck(t *testing.T) {
+	metadata := &Metadata{
+		Family: "Amaranth",
+		Subfamilies: []Subfamily{
+			// Subfamily 1
+			Subfamily{
+				Basename: "amaranth-regular",
+				Formats:  []string{"eot", "svg", "woff"},
+				Style:    "normal",
+				Weight:   400,
+			},
+			// Subfamily 2
+			Subfamily{
+				Formats: []string{"woff"},
+				Style:   "normal",
+				Weight:  400,
+			},
+			// Subfamily 3
+			Subfamily{
+				Basename: "amaranth-italic",
+				Formats:  []string{"woff"},
+				Style:    "italic",
+				Subsets: []Subset{
+					Subset{Name: "latin"},
+					Subset{},
+					Subset{Name: "unknown"},
+					Subset{Name: "invalid", UnicodeRange: "U+"},
+				},
+				Weight: 400,
+			},
+		},
+		path: "metadata.json",
+	}
+	wErrs := []string{
+		`metadata.json: subfamilies[0].formats[1]: unknown font format "svg"`,
+		`metadata.json: subfamilies[1].basename: missing basename`,
+		`metadata.json: subfamilies[1]: same weight and style as subfamilies[0]`,
+		`metadata.json: subfamilies[2].subsets[1].name: missing subset name`,
+		`metadata.json: subfamilies[2].subsets[2].unicodeRange: ` +
+			`unknown subset "unknown" without Unicode range`,
+		`metadata.json: subfamilies[2].subsets[3].unicodeRange: ` +
+			`invalid unicode range "U+"`,
+	}
+	gErrs := metadata.Check()
+	test.VerifyFatal(t, 1, 0, len(wErrs), len(gErrs))
+	for i, wErr := range wErrs {
+		j := i + 1
+		test.Verify(t, 2, j, wErr, gErrs[i].Error())
+	}
+
+	// Valid metadata.
+	metadata = new(Metadata)
+	err := metadata.Read(filepath.Join(amf, "metadata.json"))
+	test.VerifyFatal(t, 3, 0, true, nil == err)
+	test.Verify(t, 4, 0, 0, len(metadata.Check()))
 }
 
 func TestMetadataFonts(t *testing.T) {
@@ -198,6 +379,131 @@ func TestMetadataFonts(t *testing.T) {
 	}
 }
 
+func TestMetadataFontsSubsets(t *testing.T) {
+	metadata := &Metadata{
+		Family: "Amaranth",
+		Subfamilies: []Subfamily{
+			Subfamily{
+				Basename: "amaranth-regular",
+				Formats:  []string{"eot", "woff"},
+				Style:    "normal",
+				Subsets: []Subset{
+					// Subset 1
+					Subset{Name: "latin"},
+					// Subset 2
+					Subset{
+						Basename: "amaranth-regular-greek",
+						Name:     "greek",
+					},
+					// Subset 3
+					Subset{Name: "custom", UnicodeRange: "U+26"},
+					// Subset 4
+					Subset{Name: "unknown"},
+					// Subset 5
+					Subset{Name: "invalid", UnicodeRange: "U+26-25"},
+				},
+				Weight: 400,
+			},
+		},
+		path: filepath.Join(amf, "metadata.json"),
+	}
+	gFonts := metadata.Fonts()
+	test.VerifyFatal(t, 1, 0, 2, len(gFonts))
+
+	// EOT subsets can't be generated.
+	eot := gFonts[0].Subsets
+	test.VerifyFatal(t, 2, 0, 1, len(eot))
+	test.Verify(t, 3, 0, "greek", eot[0].SubsetName)
+	test.Verify(t, 4, 0, false, eot[0].Generate)
+	test.Verify(t, 5, 0, filepath.Join(amf, "amaranth-regular-greek.eot"),
+		eot[0].Path)
+
+	wSubsets := []*Font{
+		// Subset 1
+		&Font{
+			Generate:     true,
+			Path:         filepath.Join(amf, "amaranth-regular.woff"),
+			SubsetName:   "latin",
+			UnicodeRange: UnicodeRanges["latin"],
+		},
+		// Subset 2
+		&Font{
+			Path:         filepath.Join(amf, "amaranth-regular-greek.woff"),
+			SubsetName:   "greek",
+			UnicodeRange: UnicodeRanges["greek"],
+		},
+		// Subset 3
+		&Font{
+			Generate:     true,
+			Path:         filepath.Join(amf, "amaranth-regular.woff"),
+			SubsetName:   "custom",
+			UnicodeRange: "U+26",
+		},
+	}
+	gSubsets := gFonts[1].Subsets
+	test.VerifyFatal(t, 6, 0, len(wSubsets), len(gSubsets))
+	for i, wSubset := range wSubsets {
+		j := i + 1
+		gSubset := gSubsets[i]
+		test.Verify(t, 7, j, "Amaranth", gSubset.Family)
+		test.Verify(t, 8, j, true, gSubset.Format.Equal(WOFF))
+		test.Verify(t, 9, j, wSubset.Generate, gSubset.Generate)
+		test.Verify(t, 10, j, wSubset.Path, gSubset.Path)
+		test.Verify(t, 11, j, wSubset.SubsetName, gSubset.SubsetName)
+		test.Verify(t, 12, j, wSubset.UnicodeRange, gSubset.UnicodeRange)
+		test.Verify(t, 13, j, gSubset, gFonts[1].SubsetFont(wSubset.SubsetName))
+	}
+	test.Verify(t, 14, 0, true, nil == gFonts[1].SubsetFont("unknown"))
+}
+
+func TestMetadataFontsVariable(t *testing.T) {
+	wght := Axis{Tag: "wght", Min: 100, Default: 300, Max: 900}
+	metadata := &Metadata{
+		Family: "Foo",
+		Subfamilies: []Subfamily{
+			// Subfamily 1
+			Subfamily{
+				Axes:     []Axis{wght},
+				Basename: "foo-default",
+				Formats:  []string{"woff"},
+				Style:    "normal",
+			},
+			// Subfamily 2
+			Subfamily{
+				Axes:     []Axis{{Tag: "wght", Min: 500, Max: 900}},
+				Basename: "foo-nodefault",
+				Formats:  []string{"woff"},
+				Style:    "normal",
+			},
+			// Subfamily 3
+			Subfamily{
+				Axes:     []Axis{wght},
+				Basename: "foo-weight",
+				Formats:  []string{"woff"},
+				Style:    "italic",
+				Weight:   700,
+			},
+		},
+	}
+	gFonts := metadata.Fonts()
+	wWeights := []int{300, 500, 700}
+	test.VerifyFatal(t, 1, 0, len(wWeights), len(gFonts))
+	for i, wWeight := range wWeights {
+		j := i + 1
+		test.Verify(t, 2, j, wWeight, gFonts[i].Weight)
+		min, max := gFonts[i].WeightRange()
+		test.Verify(t, 3, j, int(gFonts[i].Axes[0].Min), min)
+		test.Verify(t, 4, j, 900, max)
+	}
+
+	// Static fonts cover only their own weight.
+	static := &Font{Weight: 400}
+	min, max := static.WeightRange()
+	test.Verify(t, 5, 0, 400, min)
+	test.Verify(t, 6, 0, 400, max)
+	test.Verify(t, 7, 0, true, nil == static.Axis("wght"))
+}
+
 func TestMetadataRead(t *testing.T) {
 	gMetadata := new(Metadata)
 	err := gMetadata.Read(filepath.Join(amf, "metadata.json"))
@@ -261,3 +567,143 @@ func TestMetadataRead(t *testing.T) {
 
 	test.Verify(t, 9, 0, wMetadata.path, gMetadata.path)
 }
+
+func TestMetadataMerge(t *testing.T) {
+	metadata := &Metadata{
+		Subfamilies: []Subfamily{
+			// Subfamily 1
+			Subfamily{
+				Basename: "amaranth-regular",
+				Formats:  []string{"woff"},
+			},
+			// Subfamily 2
+			Subfamily{
+				Basename: "amaranth-bold",
+				Weight:   600,
+			},
+		},
+	}
+	scanned := &Metadata{
+		Family: "Amaranth",
+		Subfamilies: []Subfamily{
+			// Subfamily 1
+			Subfamily{
+				Basename: "amaranth-regular",
+				Formats:  []string{"eot", "woff"},
+				Style:    "normal",
+				Weight:   400,
+			},
+			// Subfamily 2
+			Subfamily{
+				Basename: "amaranth-bold",
+				Formats:  []string{"eot", "woff"},
+				Style:    "normal",
+				Weight:   700,
+			},
+			// Subfamily 3
+			Subfamily{
+				Basename: "amaranth-italic",
+				Formats:  []string{"eot", "woff"},
+				Style:    "italic",
+				Weight:   400,
+			},
+		},
+		path: filepath.Join(amf, "metadata.json"),
+	}
+	metadata.Merge(scanned)
+	test.Verify(t, 1, 0, "Amaranth", metadata.Family)
+	test.Verify(t, 2, 0, scanned.path, metadata.path)
+	wSubfamilies := []Subfamily{
+		// Subfamily 1
+		Subfamily{
+			Basename: "amaranth-regular",
+			Formats:  []string{"woff"},
+			Style:    "normal",
+			Weight:   400,
+		},
+		// Subfamily 2
+		Subfamily{
+			Basename: "amaranth-bold",
+			Formats:  []string{"eot", "woff"},
+			Style:    "normal",
+			Weight:   600,
+		},
+	}
+	gSubfamilies := metadata.Subfamilies
+	test.VerifyFatal(t, 3, 0, len(wSubfamilies), len(gSubfamilies))
+	for i, wSubfamily := range wSubfamilies {
+		j := i + 1
+		gSubfamily := gSubfamilies[i]
+		test.Verify(t, 4, j, wSubfamily.Basename, gSubfamily.Basename)
+		test.VerifyFatal(t, 5, j, len(wSubfamily.Formats), len(gSubfamily.Formats))
+		for k, wFormat := range wSubfamily.Formats {
+			test.Verify(t, 6, j, wFormat, gSubfamily.Formats[k])
+		}
+		test.Verify(t, 7, j, wSubfamily.Style, gSubfamily.Style)
+		test.Verify(t, 8, j, wSubfamily.Weight, gSubfamily.Weight)
+	}
+
+	metadata = new(Metadata)
+	metadata.Merge(scanned)
+	test.Verify(t, 9, 0, len(scanned.Subfamilies), len(metadata.Subfamilies))
+}
+
+func TestMetadataScan(t *testing.T) {
+	gMetadata := new(Metadata)
+	err := gMetadata.Scan(amf)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	wMetadata := &Metadata{
+		Family: "Amaranth",
+		Subfamilies: []Subfamily{
+			// Subfamily 1
+			Subfamily{
+				Basename: "amaranth-bold",
+				Formats:  []string{"eot", "woff"},
+				Style:    "normal",
+				Weight:   700,
+			},
+			// Subfamily 2
+			Subfamily{
+				Basename: "amaranth-bolditalic",
+				Formats:  []string{"eot", "woff"},
+				Style:    "italic",
+				Weight:   700,
+			},
+			// Subfamily 3
+			Subfamily{
+				Basename: "amaranth-italic",
+				Formats:  []string{"eot", "woff"},
+				Style:    "italic",
+				Weight:   400,
+			},
+			// Subfamily 4
+			Subfamily{
+				Basename: "amaranth-regular",
+				Formats:  []string{"eot", "woff"},
+				Style:    "normal",
+				Weight:   400,
+			},
+		},
+		path: filepath.Join(amf, "metadata.json"),
+	}
+	test.Verify(t, 2, 0, wMetadata.Family, gMetadata.Family)
+	test.Verify(t, 3, 0, wMetadata.path, gMetadata.path)
+
+	gSubfamilies := gMetadata.Subfamilies
+	wSubfamilies := wMetadata.Subfamilies
+	test.VerifyFatal(t, 4, 0, len(wSubfamilies), len(gSubfamilies))
+	for i, wSubfamily := range wSubfamilies {
+		j := i + 1
+		gSubfamily := gSubfamilies[i]
+		test.Verify(t, 5, j, wSubfamily.Basename, gSubfamily.Basename)
+		test.VerifyFatal(t, 6, j, len(wSubfamily.Formats), len(gSubfamily.Formats))
+		for k, wFormat := range wSubfamily.Formats {
+			test.Verify(t, 7, j, wFormat, gSubfamily.Formats[k])
+		}
+		test.Verify(t, 8, j, wSubfamily.Style, gSubfamily.Style)
+		test.Verify(t, 9, j, wSubfamily.Weight, gSubfamily.Weight)
+	}
+
+	err = new(Metadata).Scan(fl)
+	test.Verify(t, 10, 0, false, nil == err)
+}
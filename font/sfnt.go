@@ -0,0 +1,285 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package font
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"unicode/utf16"
+)
+
+// Info represents the font information read from the name, OS/2, head, and
+// fvar tables of a font file.
+type Info struct {
+	Axes   []Axis
+	Family string
+	Italic bool
+	Weight int
+}
+
+// sfnt represents the tables of a font file using the sfnt container format,
+// used by the TTF and OTF font file formats and wrapped by the WOFF font file
+// format.
+type sfnt struct {
+	version uint32
+	tables  map[string][]byte
+}
+
+// Name IDs used by the name table.
+const (
+	nameFamily            = 1
+	nameTypographicFamily = 16
+)
+
+var errTruncated = errors.New("truncated font file")
+
+// ReadInfo reads and returns the font information of the named TTF, OTF, or
+// WOFF font file. Returns an error if the named file cannot be read or if the
+// font information cannot be parsed.
+func ReadInfo(name string) (*Info, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parseSfnt(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	info, err := s.info()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return info, nil
+}
+
+// parseSfnt parses the given TTF, OTF, or WOFF font file contents.
+func parseSfnt(b []byte) (*sfnt, error) {
+	if len(b) < 4 {
+		return nil, errTruncated
+	}
+	if string(b[:4]) == "wOFF" {
+		return parseWoff(b)
+	}
+	if len(b) < 12 {
+		return nil, errTruncated
+	}
+	s := &sfnt{
+		version: binary.BigEndian.Uint32(b),
+		tables:  make(map[string][]byte),
+	}
+	switch s.version {
+	case 0x00010000, 0x74727565, 0x4f54544f: // 1.0, "true", "OTTO"
+	default:
+		return nil, errors.New("unknown sfnt version")
+	}
+	numTables := int(binary.BigEndian.Uint16(b[4:]))
+	if len(b) < 12+16*numTables {
+		return nil, errTruncated
+	}
+	for i := 0; i < numTables; i++ {
+		record := b[12+16*i:]
+		tag := string(record[:4])
+		offset := binary.BigEndian.Uint32(record[8:])
+		length := binary.BigEndian.Uint32(record[12:])
+		if uint64(offset)+uint64(length) > uint64(len(b)) {
+			return nil, errTruncated
+		}
+		s.tables[tag] = b[offset : offset+length]
+	}
+	return s, nil
+}
+
+// parseWoff parses the given WOFF font file contents.
+func parseWoff(b []byte) (*sfnt, error) {
+	if len(b) < 44 {
+		return nil, errTruncated
+	}
+	s := &sfnt{
+		version: binary.BigEndian.Uint32(b[4:]),
+		tables:  make(map[string][]byte),
+	}
+	numTables := int(binary.BigEndian.Uint16(b[12:]))
+	if len(b) < 44+20*numTables {
+		return nil, errTruncated
+	}
+	for i := 0; i < numTables; i++ {
+		entry := b[44+20*i:]
+		tag := string(entry[:4])
+		offset := binary.BigEndian.Uint32(entry[4:])
+		compLength := binary.BigEndian.Uint32(entry[8:])
+		origLength := binary.BigEndian.Uint32(entry[12:])
+		if uint64(offset)+uint64(compLength) > uint64(len(b)) {
+			return nil, errTruncated
+		}
+		data := b[offset : offset+compLength]
+		if compLength < origLength {
+			r, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("table %q: %s", tag, err)
+			}
+			data, err = ioutil.ReadAll(r)
+			if err != nil {
+				return nil, fmt.Errorf("table %q: %s", tag, err)
+			}
+			if uint32(len(data)) != origLength {
+				return nil, fmt.Errorf("table %q: bad length", tag)
+			}
+		}
+		s.tables[tag] = data
+	}
+	return s, nil
+}
+
+// info returns the font information read from the name, OS/2, and head
+// tables.
+func (s *sfnt) info() (*Info, error) {
+	info := new(Info)
+	family, err := s.name(nameTypographicFamily)
+	if err != nil {
+		return nil, err
+	}
+	if family == "" {
+		if family, err = s.name(nameFamily); err != nil {
+			return nil, err
+		}
+	}
+	if family == "" {
+		return nil, errors.New("no family name")
+	}
+	info.Family = family
+	if os2 := s.tables["OS/2"]; len(os2) >= 64 {
+		info.Weight = int(binary.BigEndian.Uint16(os2[4:]))
+		// Bit 0 of fsSelection is the italic bit.
+		info.Italic = binary.BigEndian.Uint16(os2[62:])&1 != 0
+	} else if head := s.tables["head"]; len(head) >= 46 {
+		// No OS/2 table, fall back to the head table.
+		// Bit 0 of macStyle is the bold bit and
+		// bit 1 is the italic bit.
+		macStyle := binary.BigEndian.Uint16(head[44:])
+		info.Weight = 400
+		if macStyle&1 != 0 {
+			info.Weight = 700
+		}
+		info.Italic = macStyle&2 != 0
+	} else {
+		return nil, errors.New("no OS/2 or head table")
+	}
+	if info.Weight < 1 || info.Weight > 1000 {
+		return nil, fmt.Errorf("invalid weight class %d", info.Weight)
+	}
+	if info.Axes, err = s.axes(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// axes returns the design variation axes read from the fvar table, or nil if
+// the font is not a variable font.
+func (s *sfnt) axes() ([]Axis, error) {
+	b, ok := s.tables["fvar"]
+	if !ok {
+		return nil, nil
+	}
+	if len(b) < 16 {
+		return nil, errTruncated
+	}
+	offset := int(binary.BigEndian.Uint16(b[4:]))
+	count := int(binary.BigEndian.Uint16(b[8:]))
+	size := int(binary.BigEndian.Uint16(b[10:]))
+	if size < 20 || len(b) < offset+count*size {
+		return nil, errTruncated
+	}
+	// Axis values are 16.16 fixed-point numbers.
+	fixed := func(b []byte) float64 {
+		return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+	}
+	axes := make([]Axis, count)
+	for i := range axes {
+		record := b[offset+i*size:]
+		axes[i] = Axis{
+			Tag:     string(record[:4]),
+			Min:     fixed(record[4:]),
+			Default: fixed(record[8:]),
+			Max:     fixed(record[12:]),
+		}
+	}
+	return axes, nil
+}
+
+// name returns the English name record with the given name ID from the name
+// table, or the empty string if there is no such name record. Windows name
+// records are preferred over Unicode and Macintosh name records.
+func (s *sfnt) name(id uint16) (string, error) {
+	b := s.tables["name"]
+	if len(b) < 6 {
+		return "", errors.New("no name table")
+	}
+	count := int(binary.BigEndian.Uint16(b[2:]))
+	storage := int(binary.BigEndian.Uint16(b[4:]))
+	if len(b) < 6+12*count {
+		return "", errTruncated
+	}
+	var name string
+	var rank int
+	for i := 0; i < count; i++ {
+		record := b[6+12*i:]
+		platformID := binary.BigEndian.Uint16(record)
+		languageID := binary.BigEndian.Uint16(record[4:])
+		nameID := binary.BigEndian.Uint16(record[6:])
+		length := int(binary.BigEndian.Uint16(record[8:]))
+		offset := int(binary.BigEndian.Uint16(record[10:]))
+		if nameID != id {
+			continue
+		}
+		if storage+offset+length > len(b) {
+			return "", errTruncated
+		}
+		data := b[storage+offset : storage+offset+length]
+		var r int
+		var n string
+		switch {
+		case platformID == 3 && languageID == 0x0409:
+			// Windows, English (United States).
+			r, n = 3, decodeUtf16(data)
+		case platformID == 0:
+			// Unicode.
+			r, n = 2, decodeUtf16(data)
+		case platformID == 1 && languageID == 0:
+			// Macintosh, English.
+			r, n = 1, decodeMacRoman(data)
+		}
+		if r > rank {
+			rank, name = r, n
+		}
+	}
+	return name, nil
+}
+
+// decodeUtf16 decodes the given big-endian UTF-16 encoded string.
+func decodeUtf16(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// decodeMacRoman decodes the given Mac OS Roman encoded string. Characters
+// outside the ASCII range are replaced by the Unicode replacement character.
+func decodeMacRoman(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		if c < 0x80 {
+			r[i] = rune(c)
+		} else {
+			r[i] = '\uFFFD'
+		}
+	}
+	return string(r)
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package font
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/noll/mjau/test"
+)
+
+var osf = filepath.Join(fl, "Open Sans") // Open Sans family path.
+
+func TestReadInfo(t *testing.T) {
+	var cases = []struct {
+		Name string
+		Info *Info
+	}{
+		// Case 1
+		{
+			Name: filepath.Join(amf, "amaranth-regular.woff"),
+			Info: &Info{
+				Family: "Amaranth",
+				Italic: false,
+				Weight: 400,
+			},
+		},
+		// Case 2
+		{
+			Name: filepath.Join(amf, "amaranth-bolditalic.woff"),
+			Info: &Info{
+				Family: "Amaranth",
+				Italic: true,
+				Weight: 700,
+			},
+		},
+		// Case 3
+		{
+			Name: filepath.Join(osf, "os-semibold.woff"),
+			Info: &Info{
+				Family: "Open Sans",
+				Italic: false,
+				Weight: 600,
+			},
+		},
+		// Case 4
+		{
+			Name: filepath.Join(osf, "os-lightitalic.woff"),
+			Info: &Info{
+				Family: "Open Sans",
+				Italic: true,
+				Weight: 300,
+			},
+		},
+		// Case 5
+		{
+			Name: filepath.Join(amf, "amaranth-regular.eot"),
+		},
+		// Case 6
+		{
+			Name: filepath.Join(amf, "metadata.json"),
+		},
+		// Case 7
+		{
+			Name: filepath.Join(amf, "nonexistent.woff"),
+		},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		gInfo, err := ReadInfo(c.Name)
+		if c.Info == nil {
+			test.Verify(t, 1, j, false, nil == err)
+			continue
+		}
+		test.VerifyFatal(t, 2, j, true, nil == err)
+		test.Verify(t, 3, j, c.Info.Family, gInfo.Family)
+		test.Verify(t, 4, j, c.Info.Italic, gInfo.Italic)
+		test.Verify(t, 5, j, c.Info.Weight, gInfo.Weight)
+	}
+}
+
+func TestParseSfnt(t *testing.T) {
+	var cases = [][]byte{
+		// Case 1
+		[]byte("wOF"),
+		// Case 2
+		[]byte("wOFF\x00\x01\x00\x00"),
+		// Case 3
+		[]byte("\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00"),
+		// Case 4
+		[]byte("\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		_, err := parseSfnt(c)
+		test.Verify(t, 1, j, false, nil == err)
+	}
+}
+
+func TestSfntAxes(t *testing.T) {
+	// The fvar table header is followed by two axis records.
+	fvar := []byte("\x00\x01\x00\x00\x00\x10\x00\x02\x00\x02\x00\x14" +
+		"\x00\x00\x00\x00" +
+		"wght\x00\x64\x00\x00\x01\x90\x00\x00\x03\x84\x00\x00\x00\x00\x01\x00" +
+		"slnt\xff\xf4\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x01")
+	var cases = []struct {
+		Tables map[string][]byte
+		Axes   []Axis
+		Err    bool
+	}{
+		// Case 1
+		{
+			Tables: map[string][]byte{},
+		},
+		// Case 2
+		{
+			Tables: map[string][]byte{"fvar": fvar},
+			Axes: []Axis{
+				{Tag: "wght", Min: 100, Default: 400, Max: 900},
+				{Tag: "slnt", Min: -12, Default: 0, Max: 0},
+			},
+		},
+		// Case 3
+		{
+			Tables: map[string][]byte{"fvar": fvar[:40]},
+			Err:    true,
+		},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		s := &sfnt{tables: c.Tables}
+		axes, err := s.axes()
+		test.VerifyFatal(t, 1, j, c.Err, nil != err)
+		test.VerifyFatal(t, 2, j, len(c.Axes), len(axes))
+		for k, axis := range c.Axes {
+			test.Verify(t, 3, j, axis, axes[k])
+		}
+	}
+}
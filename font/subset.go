@@ -0,0 +1,540 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package font
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// ErrSubsetUnsupported is returned when subsetting a font file whose format
+// or glyph outlines are not supported by the subsetter.
+var ErrSubsetUnsupported = errors.New("font subsetting not supported")
+
+// Flags of the composite glyph descriptions.
+const (
+	argsAreWords   = 0x0001
+	haveScale      = 0x0008
+	moreComponents = 0x0020
+	haveXYScale    = 0x0040
+	haveTwoByTwo   = 0x0080
+)
+
+// Subset returns the contents of a subset of the font file, in the same font
+// format, containing only the glyphs needed to render the given characters.
+// Glyph indices are preserved, the glyphs which are not needed are emptied and
+// the cmap table is rebuilt to map only the given characters. Returns
+// ErrSubsetUnsupported if the font file is not a TTF, OTF, or WOFF font file
+// with TrueType outlines, or another error if it cannot be read or parsed.
+func (f *Font) Subset(runes []rune) ([]byte, error) {
+	if f.Format != TTF && f.Format != OTF && f.Format != WOFF {
+		return nil, ErrSubsetUnsupported
+	}
+	b, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+	s, err := parseSfnt(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.subset(runes); err != nil {
+		return nil, err
+	}
+	if f.Format == WOFF {
+		return encodeWoff(s.bytes())
+	}
+	return s.bytes(), nil
+}
+
+// subset replaces the glyf, loca, and cmap tables with the ones of a subset
+// containing only the glyphs needed to render the given characters.
+func (s *sfnt) subset(runes []rune) error {
+	glyf, ok := s.tables["glyf"]
+	if !ok {
+		// No TrueType outlines.
+		return ErrSubsetUnsupported
+	}
+	head, maxp := s.tables["head"], s.tables["maxp"]
+	if len(head) < 54 || len(maxp) < 6 {
+		return errTruncated
+	}
+	longLoca := binary.BigEndian.Uint16(head[50:]) != 0
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	loca, err := s.loca(numGlyphs, longLoca)
+	if err != nil {
+		return err
+	}
+	cmap, err := s.cmap()
+	if err != nil {
+		return err
+	}
+	// Always keep the .notdef glyph.
+	keep := map[uint16]bool{0: true}
+	queue := []uint16{0}
+	mapping := make(map[rune]uint16)
+	for _, r := range runes {
+		if g, ok := cmap[r]; ok && int(g) < numGlyphs {
+			mapping[r] = g
+			if !keep[g] {
+				keep[g] = true
+				queue = append(queue, g)
+			}
+		}
+	}
+	// Keep the components of the composite glyphs.
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+		if loca[g] > loca[g+1] || int(loca[g+1]) > len(glyf) {
+			return errTruncated
+		}
+		for _, c := range components(glyf[loca[g]:loca[g+1]]) {
+			if int(c) < numGlyphs && !keep[c] {
+				keep[c] = true
+				queue = append(queue, c)
+			}
+		}
+	}
+	// Rebuild the glyf and loca tables.
+	var newGlyf []byte
+	newLoca := make([]uint32, numGlyphs+1)
+	for g := 0; g < numGlyphs; g++ {
+		newLoca[g] = uint32(len(newGlyf))
+		if keep[uint16(g)] {
+			newGlyf = append(newGlyf, glyf[loca[g]:loca[g+1]]...)
+			if len(newGlyf)%2 != 0 {
+				newGlyf = append(newGlyf, 0)
+			}
+		}
+	}
+	newLoca[numGlyphs] = uint32(len(newGlyf))
+	var locaTable []byte
+	for _, offset := range newLoca {
+		if longLoca {
+			locaTable = appendUint32(locaTable, offset)
+		} else {
+			locaTable = appendUint16(locaTable, uint16(offset/2))
+		}
+	}
+	cmapTable, err := encodeCmap(mapping)
+	if err != nil {
+		return err
+	}
+	s.tables["glyf"] = newGlyf
+	s.tables["loca"] = locaTable
+	s.tables["cmap"] = cmapTable
+	// The digital signature is no longer valid.
+	delete(s.tables, "DSIG")
+	return nil
+}
+
+// loca returns the glyph offsets read from the loca table.
+func (s *sfnt) loca(numGlyphs int, long bool) ([]uint32, error) {
+	b := s.tables["loca"]
+	offsets := make([]uint32, numGlyphs+1)
+	for i := range offsets {
+		if long {
+			if len(b) < 4*(i+1) {
+				return nil, errTruncated
+			}
+			offsets[i] = binary.BigEndian.Uint32(b[4*i:])
+		} else {
+			if len(b) < 2*(i+1) {
+				return nil, errTruncated
+			}
+			offsets[i] = 2 * uint32(binary.BigEndian.Uint16(b[2*i:]))
+		}
+	}
+	return offsets, nil
+}
+
+// cmap returns the character to glyph index mapping read from the best
+// Unicode subtable of the cmap table.
+func (s *sfnt) cmap() (map[rune]uint16, error) {
+	b := s.tables["cmap"]
+	if len(b) < 4 {
+		return nil, errors.New("no cmap table")
+	}
+	numTables := int(binary.BigEndian.Uint16(b[2:]))
+	if len(b) < 4+8*numTables {
+		return nil, errTruncated
+	}
+	var best []byte
+	var rank int
+	for i := 0; i < numTables; i++ {
+		record := b[4+8*i:]
+		platformID := binary.BigEndian.Uint16(record)
+		encodingID := binary.BigEndian.Uint16(record[2:])
+		offset := binary.BigEndian.Uint32(record[4:])
+		if uint64(offset)+4 > uint64(len(b)) {
+			return nil, errTruncated
+		}
+		subtable := b[offset:]
+		format := binary.BigEndian.Uint16(subtable)
+		unicode := platformID == 0 ||
+			(platformID == 3 && (encodingID == 1 || encodingID == 10))
+		var r int
+		switch {
+		case unicode && format == 12:
+			r = 2
+		case unicode && format == 4:
+			r = 1
+		}
+		if r > rank {
+			rank, best = r, subtable
+		}
+	}
+	switch rank {
+	case 2:
+		return cmap12(best)
+	case 1:
+		return cmap4(best)
+	}
+	return nil, errors.New("no Unicode cmap subtable")
+}
+
+// cmap4 parses the given format 4 cmap subtable.
+func cmap4(b []byte) (map[rune]uint16, error) {
+	if len(b) < 14 {
+		return nil, errTruncated
+	}
+	segCount := int(binary.BigEndian.Uint16(b[6:])) / 2
+	if len(b) < 16+8*segCount {
+		return nil, errTruncated
+	}
+	ends := b[14:]
+	starts := ends[2*segCount+2:]
+	deltas := starts[2*segCount:]
+	rangeOffsets := deltas[2*segCount:]
+	m := make(map[rune]uint16)
+	for i := 0; i < segCount; i++ {
+		end := binary.BigEndian.Uint16(ends[2*i:])
+		start := binary.BigEndian.Uint16(starts[2*i:])
+		delta := binary.BigEndian.Uint16(deltas[2*i:])
+		rangeOffset := int(binary.BigEndian.Uint16(rangeOffsets[2*i:]))
+		for c := uint32(start); c <= uint32(end) && c != 0xffff; c++ {
+			var g uint16
+			if rangeOffset == 0 {
+				g = uint16(c) + delta
+			} else {
+				// The range offset is relative to its own
+				// position in the idRangeOffset array.
+				pos := 2*i + rangeOffset + 2*int(c-uint32(start))
+				if pos+2 > len(rangeOffsets) {
+					return nil, errTruncated
+				}
+				if g = binary.BigEndian.Uint16(rangeOffsets[pos:]); g != 0 {
+					g += delta
+				}
+			}
+			if g != 0 {
+				m[rune(c)] = g
+			}
+		}
+	}
+	return m, nil
+}
+
+// cmap12 parses the given format 12 cmap subtable.
+func cmap12(b []byte) (map[rune]uint16, error) {
+	if len(b) < 16 {
+		return nil, errTruncated
+	}
+	numGroups := int(binary.BigEndian.Uint32(b[12:]))
+	if numGroups < 0 || len(b) < 16+12*numGroups {
+		return nil, errTruncated
+	}
+	m := make(map[rune]uint16)
+	for i := 0; i < numGroups; i++ {
+		group := b[16+12*i:]
+		start := binary.BigEndian.Uint32(group)
+		end := binary.BigEndian.Uint32(group[4:])
+		glyph := binary.BigEndian.Uint32(group[8:])
+		if end < start || end > 0x10ffff {
+			return nil, errors.New("invalid cmap group")
+		}
+		for c := start; c <= end; c++ {
+			if g := glyph + c - start; g != 0 && g <= 0xffff {
+				m[rune(c)] = uint16(g)
+			}
+		}
+	}
+	return m, nil
+}
+
+// components returns the glyph indices of the components of the given glyph
+// description, if it is a composite glyph description.
+func components(b []byte) []uint16 {
+	if len(b) < 10 || int16(binary.BigEndian.Uint16(b)) >= 0 {
+		// Empty or simple glyph description.
+		return nil
+	}
+	var glyphs []uint16
+	for pos := 10; pos+4 <= len(b); {
+		flags := binary.BigEndian.Uint16(b[pos:])
+		glyphs = append(glyphs, binary.BigEndian.Uint16(b[pos+2:]))
+		pos += 4
+		if flags&argsAreWords != 0 {
+			pos += 4
+		} else {
+			pos += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			pos += 2
+		case flags&haveXYScale != 0:
+			pos += 4
+		case flags&haveTwoByTwo != 0:
+			pos += 8
+		}
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	return glyphs
+}
+
+// encodeCmap returns a cmap table mapping the given characters, containing a
+// format 4 subtable for the Basic Multilingual Plane and, if needed, a format
+// 12 subtable for all the characters.
+func encodeCmap(mapping map[rune]uint16) ([]byte, error) {
+	runes := make([]rune, 0, len(mapping))
+	for r := range mapping {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	// Group the characters in ranges of consecutive
+	// characters mapped to consecutive glyphs.
+	type group struct {
+		start, end rune
+		glyph      uint16
+	}
+	var groups []group
+	for _, r := range runes {
+		g := mapping[r]
+		if n := len(groups); n > 0 {
+			last := &groups[n-1]
+			if r == last.end+1 && rune(g) == rune(last.glyph)+r-last.start {
+				last.end = r
+				continue
+			}
+		}
+		groups = append(groups, group{r, r, g})
+	}
+	var bmp []group
+	for _, g := range groups {
+		if g.end <= 0xfffe {
+			bmp = append(bmp, g)
+		} else if g.start <= 0xfffe {
+			bmp = append(bmp, group{g.start, 0xfffe, g.glyph})
+		}
+	}
+	// Format 4 subtable, terminated by the mandatory
+	// 0xffff segment.
+	segCount := len(bmp) + 1
+	length := 16 + 8*segCount
+	if length > 0xffff {
+		return nil, errors.New("too many characters")
+	}
+	searchRange, entrySelector := 1, 0
+	for searchRange*2 <= segCount {
+		searchRange *= 2
+		entrySelector++
+	}
+	searchRange *= 2
+	var f4 []byte
+	f4 = appendUint16(f4, 4)
+	f4 = appendUint16(f4, uint16(length))
+	f4 = appendUint16(f4, 0)
+	f4 = appendUint16(f4, uint16(2*segCount))
+	f4 = appendUint16(f4, uint16(searchRange))
+	f4 = appendUint16(f4, uint16(entrySelector))
+	f4 = appendUint16(f4, uint16(2*segCount-searchRange))
+	for _, g := range bmp {
+		f4 = appendUint16(f4, uint16(g.end))
+	}
+	f4 = appendUint16(f4, 0xffff)
+	f4 = appendUint16(f4, 0)
+	for _, g := range bmp {
+		f4 = appendUint16(f4, uint16(g.start))
+	}
+	f4 = appendUint16(f4, 0xffff)
+	for _, g := range bmp {
+		f4 = appendUint16(f4, g.glyph-uint16(g.start))
+	}
+	f4 = appendUint16(f4, 1)
+	for i := 0; i < segCount; i++ {
+		f4 = appendUint16(f4, 0)
+	}
+	subtables := [][]byte{f4}
+	encodings := []uint16{1}
+	if len(groups) > 0 && groups[len(groups)-1].end > 0xffff {
+		// Format 12 subtable.
+		var f12 []byte
+		f12 = appendUint16(f12, 12)
+		f12 = appendUint16(f12, 0)
+		f12 = appendUint32(f12, uint32(16+12*len(groups)))
+		f12 = appendUint32(f12, 0)
+		f12 = appendUint32(f12, uint32(len(groups)))
+		for _, g := range groups {
+			f12 = appendUint32(f12, uint32(g.start))
+			f12 = appendUint32(f12, uint32(g.end))
+			f12 = appendUint32(f12, uint32(g.glyph))
+		}
+		subtables = append(subtables, f12)
+		encodings = append(encodings, 10)
+	}
+	var b []byte
+	b = appendUint16(b, 0)
+	b = appendUint16(b, uint16(len(subtables)))
+	offset := 4 + 8*len(subtables)
+	for i, subtable := range subtables {
+		b = appendUint16(b, 3)
+		b = appendUint16(b, encodings[i])
+		b = appendUint32(b, uint32(offset))
+		offset += len(subtable)
+	}
+	for _, subtable := range subtables {
+		b = append(b, subtable...)
+	}
+	return b, nil
+}
+
+// bytes returns the contents of a font file using the sfnt container format
+// containing the tables, with recomputed checksums.
+func (s *sfnt) bytes() []byte {
+	tags := make([]string, 0, len(s.tables))
+	for tag := range s.tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	numTables := len(tags)
+	searchRange, entrySelector := 1, 0
+	for searchRange*2 <= numTables {
+		searchRange *= 2
+		entrySelector++
+	}
+	searchRange *= 16
+	var b []byte
+	b = appendUint32(b, s.version)
+	b = appendUint16(b, uint16(numTables))
+	b = appendUint16(b, uint16(searchRange))
+	b = appendUint16(b, uint16(entrySelector))
+	b = appendUint16(b, uint16(16*numTables-searchRange))
+	offset := 12 + 16*numTables
+	var headOffset int
+	for _, tag := range tags {
+		table := s.tables[tag]
+		if tag == "head" && len(table) >= 12 {
+			// The checksum adjustment is computed
+			// once the whole font file is written.
+			table = append([]byte(nil), table...)
+			binary.BigEndian.PutUint32(table[8:], 0)
+			s.tables[tag] = table
+			headOffset = offset
+		}
+		b = append(b, tag...)
+		b = appendUint32(b, checksum(table))
+		b = appendUint32(b, uint32(offset))
+		b = appendUint32(b, uint32(len(table)))
+		offset += (len(table) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		table := s.tables[tag]
+		b = append(b, table...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+	}
+	if headOffset != 0 {
+		adjustment := 0xb1b0afba - checksum(b)
+		binary.BigEndian.PutUint32(b[headOffset+8:], adjustment)
+		binary.BigEndian.PutUint32(s.tables["head"][8:], adjustment)
+	}
+	return b
+}
+
+// encodeWoff returns the contents of a WOFF font file wrapping the given
+// contents of a font file using the sfnt container format.
+func encodeWoff(b []byte) ([]byte, error) {
+	numTables := int(binary.BigEndian.Uint16(b[4:]))
+	type entry struct {
+		record []byte
+		data   []byte
+	}
+	entries := make([]entry, numTables)
+	totalSfntSize := 12 + 16*numTables
+	for i := range entries {
+		record := b[12+16*i : 28+16*i]
+		offset := binary.BigEndian.Uint32(record[8:])
+		length := binary.BigEndian.Uint32(record[12:])
+		table := b[offset : offset+length]
+		var buf bytes.Buffer
+		w, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		w.Write(table)
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		data := table
+		if buf.Len() < len(table) {
+			data = buf.Bytes()
+		}
+		entries[i] = entry{record, data}
+		totalSfntSize += (len(table) + 3) &^ 3
+	}
+	offset := 44 + 20*numTables
+	var dir []byte
+	var data []byte
+	for _, e := range entries {
+		dir = append(dir, e.record[:4]...)
+		dir = appendUint32(dir, uint32(offset+len(data)))
+		dir = appendUint32(dir, uint32(len(e.data)))
+		dir = append(dir, e.record[12:16]...)
+		dir = append(dir, e.record[4:8]...)
+		data = append(data, e.data...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	var w []byte
+	w = append(w, "wOFF"...)
+	w = append(w, b[:4]...)
+	w = appendUint32(w, uint32(offset+len(data)))
+	w = appendUint16(w, uint16(numTables))
+	w = appendUint16(w, 0)
+	w = appendUint32(w, uint32(totalSfntSize))
+	w = appendUint16(w, 1)
+	w = appendUint16(w, 0)
+	// No metadata and private data blocks.
+	w = append(w, make([]byte, 20)...)
+	w = append(w, dir...)
+	w = append(w, data...)
+	return w, nil
+}
+
+// checksum returns the checksum of the given table.
+func checksum(b []byte) (sum uint32) {
+	for i := 0; i < len(b); i += 4 {
+		var word [4]byte
+		copy(word[:], b[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package font
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/noll/mjau/test"
+)
+
+func TestFontSubset(t *testing.T) {
+	var cases = []struct {
+		Font  Font
+		Runes []rune
+		Err   error
+	}{
+		// Case 1
+		{
+			Font: Font{
+				Format: WOFF,
+				Path:   filepath.Join(amf, "amaranth-regular.woff"),
+			},
+			Runes: []rune("Hi"),
+		},
+		// Case 2
+		{
+			// Composite glyphs.
+			Font: Font{
+				Format: WOFF,
+				Path:   filepath.Join(osf, "os-regular.woff"),
+			},
+			Runes: []rune("Wörld"),
+		},
+		// Case 3
+		{
+			Font: Font{
+				Format: EOT,
+				Path:   filepath.Join(amf, "amaranth-regular.eot"),
+			},
+			Runes: []rune("Hi"),
+			Err:   ErrSubsetUnsupported,
+		},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		data, err := c.Font.Subset(c.Runes)
+		if c.Err != nil {
+			test.Verify(t, 1, j, c.Err, err)
+			continue
+		}
+		test.VerifyFatal(t, 2, j, true, nil == err)
+		contents, err := c.Font.Contents()
+		test.VerifyFatal(t, 3, j, true, nil == err)
+		test.Verify(t, 4, j, true, len(data) < len(contents))
+
+		// The subset is in the same font format
+		// and maps only the given characters
+		// to the same glyphs.
+		test.Verify(t, 5, j, "wOFF", string(data[:4]))
+		orig, err := parseSfnt(contents)
+		test.VerifyFatal(t, 6, j, true, nil == err)
+		origCmap, err := orig.cmap()
+		test.VerifyFatal(t, 7, j, true, nil == err)
+		s, err := parseSfnt(data)
+		test.VerifyFatal(t, 8, j, true, nil == err)
+		cmap, err := s.cmap()
+		test.VerifyFatal(t, 9, j, true, nil == err)
+		test.Verify(t, 10, j, len(c.Runes), len(cmap))
+		for _, r := range c.Runes {
+			test.Verify(t, 11, j, origCmap[r], cmap[r])
+		}
+		test.Verify(t, 12, j, len(orig.tables), len(s.tables))
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package font
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// UnicodeRanges holds the values of the unicode-range descriptor of the
+// built-in named subsets, used by the subsets which don't define their own
+// Unicode range.
+var UnicodeRanges = map[string]string{
+	"cyrillic": "U+0301, U+0400-045F, U+0490-0491, U+04B0-04B1, " +
+		"U+2116",
+	"cyrillic-ext": "U+0460-052F, U+1C80-1C88, U+20B4, U+2DE0-2DFF, " +
+		"U+A640-A69F, U+FE2E-FE2F",
+	"greek":     "U+0370-03FF",
+	"greek-ext": "U+1F00-1FFF",
+	"latin": "U+0000-00FF, U+0131, U+0152-0153, U+02BB-02BC, U+02C6, " +
+		"U+02DA, U+02DC, U+2000-206F, U+2074, U+20AC, U+2122, U+2191, " +
+		"U+2193, U+2212, U+2215, U+FEFF, U+FFFD",
+	"latin-ext": "U+0100-024F, U+0259, U+1E00-1EFF, U+2020, " +
+		"U+20A0-20AB, U+20AD-20CF, U+2113, U+2C60-2C7F, U+A720-A7FF",
+	"vietnamese": "U+0102-0103, U+0110-0111, U+0128-0129, U+0168-0169, " +
+		"U+01A0-01A1, U+01AF-01B0, U+1EA0-1EF9, U+20AB",
+}
+
+// ParseUnicodeRange parses the given value of a unicode-range descriptor,
+// a comma-separated list of code points (U+26), intervals (U+0000-00FF), and
+// wildcard ranges (U+4??), and returns the characters it contains.
+// Returns an error if the value cannot be parsed.
+func ParseUnicodeRange(s string) ([]rune, error) {
+	var runes []rune
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if len(r) < 3 || strings.ToUpper(r[:2]) != "U+" {
+			return nil, fmt.Errorf("invalid unicode range %q", r)
+		}
+		var lo, hi string
+		if i := strings.Index(r, "-"); i >= 0 {
+			lo, hi = r[2:i], r[i+1:]
+		} else if strings.Contains(r, "?") {
+			lo = strings.Replace(r[2:], "?", "0", -1)
+			hi = strings.Replace(r[2:], "?", "F", -1)
+		} else {
+			lo, hi = r[2:], r[2:]
+		}
+		first, err := parseCodePoint(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unicode range %q", r)
+		}
+		last, err := parseCodePoint(hi)
+		if err != nil || last < first {
+			return nil, fmt.Errorf("invalid unicode range %q", r)
+		}
+		for c := first; c <= last; c++ {
+			runes = append(runes, c)
+		}
+	}
+	return runes, nil
+}
+
+// parseCodePoint parses the given hexadecimal code point.
+func parseCodePoint(s string) (rune, error) {
+	if len(s) == 0 || len(s) > 6 {
+		return 0, strconv.ErrSyntax
+	}
+	c, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	if c > unicode.MaxRune {
+		return 0, strconv.ErrRange
+	}
+	return rune(c), nil
+}
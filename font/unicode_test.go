@@ -0,0 +1,55 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package font
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/noll/mjau/test"
+)
+
+func TestParseUnicodeRange(t *testing.T) {
+	var cases = []struct {
+		UnicodeRange string
+		Runes        []rune
+	}{
+		// Case 1
+		{"U+26", []rune{0x26}},
+		// Case 2
+		{"U+0041-0043, u+61", []rune("ABCa")},
+		// Case 3
+		{"U+4?", []rune("@ABCDEFGHIJKLMNO")},
+		// Case 4
+		{"", nil},
+		// Case 5
+		{"U+", nil},
+		// Case 6
+		{"U+0043-0041", nil},
+		// Case 7
+		{"U+110000", nil},
+		// Case 8
+		{"0041", nil},
+		// Case 9
+		{"U+0041,", nil},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		runes, err := ParseUnicodeRange(c.UnicodeRange)
+		if c.Runes == nil {
+			test.Verify(t, 1, j, false, nil == err)
+			continue
+		}
+		test.VerifyFatal(t, 2, j, true, nil == err)
+		test.Verify(t, 3, j, string(c.Runes), string(runes))
+	}
+
+	// The built-in Unicode ranges are valid.
+	for name, unicodeRange := range UnicodeRanges {
+		_, err := ParseUnicodeRange(unicodeRange)
+		test.Verify(t, 4, 0, name+": <nil>", fmt.Sprintf("%s: %v", name, err))
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Access log formats.
+const (
+	CommonLogFormat   = "common"   // Common Log Format.
+	CombinedLogFormat = "combined" // Combined Log Format.
+	JsonLogFormat     = "json"     // One JSON object per line.
+)
+
+// AccessLog represents an HTTP access log, recording one line per request.
+// An access log opened from a file may be reopened, so that the file can be
+// rotated while the server is running.
+type AccessLog struct {
+	file   *os.File
+	format string
+	mu     sync.Mutex
+	name   string
+	w      io.Writer
+}
+
+// accessEntry represents a single request recorded in an access log.
+type accessEntry struct {
+	Bytes      int64
+	Latency    time.Duration
+	Method     string
+	Proto      string
+	Referer    string
+	RemoteAddr string
+	Status     int
+	Time       time.Time
+	URL        string
+	UserAgent  string
+}
+
+// NewAccessLog creates and returns a new access log writing to w, using the
+// given format, one of CommonLogFormat, CombinedLogFormat, or JsonLogFormat.
+// Returns an error if the format is not valid.
+func NewAccessLog(w io.Writer, format string) (*AccessLog, error) {
+	switch format {
+	case CommonLogFormat, CombinedLogFormat, JsonLogFormat:
+	default:
+		return nil, fmt.Errorf("invalid access log format %q", format)
+	}
+	return &AccessLog{format: format, w: w}, nil
+}
+
+// OpenAccessLog creates and returns a new access log, like NewAccessLog,
+// writing to the named file, which is created if needed and appended to, or
+// to standard output if the name is "-". Returns an error if the file cannot
+// be opened or if the format is not valid.
+func OpenAccessLog(name, format string) (*AccessLog, error) {
+	if name == "-" {
+		return NewAccessLog(os.Stdout, format)
+	}
+	file, err := openAccessLogFile(name)
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewAccessLog(file, format)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.file, l.name = file, name
+	return l, nil
+}
+
+func openAccessLogFile(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+// Reopen closes and opens again the file the access log writes to, if any,
+// so that a rotated file is replaced by a new one. Returns an error if the
+// file cannot be opened, in which case the access log keeps writing to the
+// previous file.
+func (l *AccessLog) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	file, err := openAccessLogFile(l.name)
+	if err != nil {
+		return err
+	}
+	l.file.Close()
+	l.file, l.w = file, file
+	return nil
+}
+
+// Close closes the file the access log writes to, if any.
+func (l *AccessLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// write records the given entry in the access log.
+func (l *AccessLog) write(e *accessEntry) {
+	line := l.line(e)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+// line returns the given entry formatted as a line of the access log.
+func (l *AccessLog) line(e *accessEntry) []byte {
+	buf := new(bytes.Buffer)
+	if l.format == JsonLogFormat {
+		json.NewEncoder(buf).Encode(struct {
+			Time       string  `json:"time"`
+			RemoteAddr string  `json:"remote_addr"`
+			Method     string  `json:"method"`
+			URL        string  `json:"url"`
+			Proto      string  `json:"proto"`
+			Status     int     `json:"status"`
+			Bytes      int64   `json:"bytes"`
+			Referer    string  `json:"referer"`
+			UserAgent  string  `json:"user_agent"`
+			Latency    float64 `json:"latency_ms"`
+		}{
+			e.Time.Format(time.RFC3339Nano), e.RemoteAddr, e.Method,
+			e.URL, e.Proto, e.Status, e.Bytes, e.Referer, e.UserAgent,
+			float64(e.Latency) / float64(time.Millisecond),
+		})
+		return buf.Bytes()
+	}
+	// The size of a response without body is logged as "-".
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	request := e.Method + " " + e.URL + " " + e.Proto
+	fmt.Fprintf(buf, "%s - - [%s] %s %d %s", e.RemoteAddr,
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"), strconv.Quote(request),
+		e.Status, size)
+	if l.format == CombinedLogFormat {
+		fmt.Fprintf(buf, " %s %s", quoteField(e.Referer),
+			quoteField(e.UserAgent))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// quoteField quotes the given field of a line of the access log, using "-"
+// for an empty field.
+func quoteField(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+// accessResponseWriter records the status code and the size of the body of
+// a response.
+type accessResponseWriter struct {
+	bytes  int64
+	status int
+	http.ResponseWriter
+}
+
+func (w *accessResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// MakeAccessLogHandler is a http handler wrapper which records each request
+// served by the wrapped http handler in the given access log. It should wrap
+// the other http handler wrappers, so that the size of the response body is
+// the number of bytes actually sent.
+func MakeAccessLogHandler(fn http.HandlerFunc, l *AccessLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		aw := &accessResponseWriter{ResponseWriter: w}
+		fn(aw, r)
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+		remoteAddr := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			remoteAddr = host
+		}
+		l.write(&accessEntry{
+			Bytes:      aw.bytes,
+			Latency:    time.Since(start),
+			Method:     r.Method,
+			Proto:      r.Proto,
+			Referer:    r.Referer(),
+			RemoteAddr: remoteAddr,
+			Status:     aw.status,
+			Time:       start,
+			URL:        r.URL.RequestURI(),
+			UserAgent:  r.UserAgent(),
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noll/mjau/test"
+)
+
+func TestAccessLogLine(t *testing.T) {
+	entry := &accessEntry{
+		Bytes:      1234,
+		Latency:    1500 * time.Microsecond,
+		Method:     "GET",
+		Proto:      "HTTP/1.1",
+		Referer:    "http://example.com/",
+		RemoteAddr: "127.0.0.1",
+		Status:     200,
+		Time:       time.Date(2012, 10, 10, 13, 55, 36, 0, time.UTC),
+		URL:        "/css/?family=Amaranth",
+		UserAgent:  `Mozilla/5.0 "Test"`,
+	}
+	empty := *entry
+	empty.Bytes = 0
+	empty.Referer = ""
+	empty.Status = 304
+
+	var cases = []struct {
+		Format string
+		Entry  *accessEntry
+		Line   string
+	}{
+		// Case 1
+		{CommonLogFormat, entry, `127.0.0.1 - - [10/Oct/2012:13:55:36 +0000] ` +
+			`"GET /css/?family=Amaranth HTTP/1.1" 200 1234` + "\n"},
+		// Case 2
+		{CombinedLogFormat, entry, `127.0.0.1 - - [10/Oct/2012:13:55:36 +0000] ` +
+			`"GET /css/?family=Amaranth HTTP/1.1" 200 1234 ` +
+			`"http://example.com/" "Mozilla/5.0 \"Test\""` + "\n"},
+		// Case 3
+		{CombinedLogFormat, &empty, `127.0.0.1 - - [10/Oct/2012:13:55:36 +0000] ` +
+			`"GET /css/?family=Amaranth HTTP/1.1" 304 - ` +
+			`"-" "Mozilla/5.0 \"Test\""` + "\n"},
+		// Case 4
+		{JsonLogFormat, entry, `{"time":"2012-10-10T13:55:36Z",` +
+			`"remote_addr":"127.0.0.1","method":"GET",` +
+			`"url":"/css/?family=Amaranth","proto":"HTTP/1.1",` +
+			`"status":200,"bytes":1234,"referer":"http://example.com/",` +
+			`"user_agent":"Mozilla/5.0 \"Test\"","latency_ms":1.5}` + "\n"},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		l, err := NewAccessLog(ioutil.Discard, c.Format)
+		test.VerifyFatal(t, 1, j, true, nil == err)
+		test.Verify(t, 2, j, c.Line, string(l.line(c.Entry)))
+	}
+
+	_, err := NewAccessLog(ioutil.Discard, "apache")
+	test.Verify(t, 3, 0, false, nil == err)
+}
+
+func TestMakeAccessLogHandler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l, err := NewAccessLog(buf, CombinedLogFormat)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+
+	var cases = []struct {
+		Handler http.HandlerFunc
+		Line    string
+	}{
+		// Case 1
+		{helloHandler, `"GET /?a=b HTTP/1.1" 200 4 "http://example.com/" "test"`},
+		// Case 2
+		{
+			func(w http.ResponseWriter, r *http.Request) {},
+			`"GET /?a=b HTTP/1.1" 200 - "http://example.com/" "test"`,
+		},
+		// Case 3
+		{
+			func(w http.ResponseWriter, r *http.Request) { NotFound(w, r) },
+			`"GET /?a=b HTTP/1.1" 404 - "http://example.com/" "test"`,
+		},
+		// Case 4
+		{
+			MakeCompressHandler(helloHandler),
+			`"GET /?a=b HTTP/1.1" 200 29 "http://example.com/" "test"`,
+		},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		buf.Reset()
+		req, err := http.NewRequest("GET", "/?a=b", nil)
+		test.VerifyFatal(t, 1, j, true, nil == err)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Referer", "http://example.com/")
+		req.Header.Set("User-Agent", "test")
+		MakeAccessLogHandler(c.Handler, l)(httptest.NewRecorder(), req)
+		line := buf.String()
+		test.Verify(t, 2, j, true, strings.HasPrefix(line, "192.0.2.1 - - ["))
+		test.Verify(t, 3, j, true, strings.HasSuffix(line, c.Line+"\n"))
+	}
+}
+
+func TestAccessLogReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "access.log")
+	l, err := OpenAccessLog(name, CommonLogFormat)
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+	defer l.Close()
+
+	req, err := http.NewRequest("GET", "/", nil)
+	test.VerifyFatal(t, 3, 0, true, nil == err)
+	handler := MakeAccessLogHandler(helloHandler, l)
+	handler(httptest.NewRecorder(), req)
+	// Rotate the access log file.
+	rotated := name + ".1"
+	err = os.Rename(name, rotated)
+	test.VerifyFatal(t, 4, 0, true, nil == err)
+	err = l.Reopen()
+	test.VerifyFatal(t, 5, 0, true, nil == err)
+	handler(httptest.NewRecorder(), req)
+	handler(httptest.NewRecorder(), req)
+
+	data, err := ioutil.ReadFile(rotated)
+	test.VerifyFatal(t, 6, 0, true, nil == err)
+	test.Verify(t, 7, 0, 1, strings.Count(string(data), "\n"))
+	data, err = ioutil.ReadFile(name)
+	test.VerifyFatal(t, 8, 0, true, nil == err)
+	test.Verify(t, 9, 0, 2, strings.Count(string(data), "\n"))
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/noll/mjau/font"
+)
+
+var (
+	// Font formats supported by modern user agents.
+	woff2Formats = []font.Format{font.WOFF2, font.WOFF, font.TTF, font.OTF}
+	// Font formats supported by user agents without WOFF2 support.
+	woffFormats = []font.Format{font.WOFF, font.TTF, font.OTF}
+	// Font formats supported by user agents without WOFF support.
+	ttfFormats = []font.Format{font.TTF, font.OTF}
+	// Font formats supported by Internet Explorer 9 and later.
+	ieFormats = []font.Format{font.WOFF, font.EOT}
+	// Font formats supported by Internet Explorer prior to version 9.
+	oldIeFormats = []font.Format{font.EOT}
+	// Font formats used for unknown user agents.
+	defaultFormats = []font.Format{font.WOFF, font.WOFF2, font.TTF, font.OTF,
+		font.EOT}
+)
+
+// AgentFormats returns the font formats supported by the user agent identified
+// by the given User-Agent HTTP request header, ordered by preference. Unknown
+// user agents are assumed to support all the font formats, WOFF being the
+// preferred one.
+func AgentFormats(userAgent string) []font.Format {
+	if major, _, ok := agentVersion(userAgent, "MSIE "); ok {
+		if major < 9 {
+			return oldIeFormats
+		}
+		return ieFormats
+	}
+	if strings.Contains(userAgent, "Trident/") {
+		// Internet Explorer 11 and later.
+		return ieFormats
+	}
+	if major, _, ok := agentVersion(userAgent, "Edge/"); ok {
+		// Microsoft Edge prior to version 79.
+		if major < 14 {
+			return woffFormats
+		}
+		return woff2Formats
+	}
+	if major, _, ok := agentVersion(userAgent, "Firefox/"); ok {
+		if major < 39 {
+			return woffFormats
+		}
+		return woff2Formats
+	}
+	if major, _, ok := agentVersion(userAgent, "Chrome/"); ok {
+		if major < 36 {
+			return woffFormats
+		}
+		return woff2Formats
+	}
+	if major, minor, ok := agentVersion(userAgent, "Android "); ok {
+		// Android browser.
+		if major < 4 || (major == 4 && minor < 4) {
+			return ttfFormats
+		}
+		return woffFormats
+	}
+	if strings.Contains(userAgent, "Safari/") {
+		if major, minor, ok := agentVersion(userAgent, "Version/"); ok {
+			switch {
+			case major >= 10:
+				return woff2Formats
+			case major > 5 || (major == 5 && minor >= 1):
+				return woffFormats
+			}
+			return ttfFormats
+		}
+	}
+	return defaultFormats
+}
+
+// agentVersion returns the major and minor version numbers following the
+// given product token in the given User-Agent HTTP request header. The ok
+// result reports whether the product token and the major version were found.
+func agentVersion(userAgent, token string) (major, minor int, ok bool) {
+	i := strings.Index(userAgent, token)
+	if i < 0 {
+		return 0, 0, false
+	}
+	version := userAgent[i+len(token):]
+	if end := strings.IndexAny(version, " ;)"); end >= 0 {
+		version = version[:end]
+	}
+	numbers := strings.FieldsFunc(version, func(r rune) bool {
+		return r == '.' || r == '_'
+	})
+	if len(numbers) == 0 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(numbers[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	if len(numbers) > 1 {
+		minor, _ = strconv.Atoi(numbers[1])
+	}
+	return major, minor, true
+}
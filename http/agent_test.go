@@ -0,0 +1,98 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"testing"
+
+	"github.com/noll/mjau/font"
+	"github.com/noll/mjau/test"
+)
+
+func TestAgentFormats(t *testing.T) {
+	var cases = []struct {
+		UserAgent string
+		Formats   []font.Format
+	}{
+		// Case 1
+		{
+			"Mozilla/4.0 (compatible; MSIE 6.0; Windows NT 5.1)",
+			oldIeFormats,
+		},
+		// Case 2
+		{
+			"Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1; Trident/6.0)",
+			ieFormats,
+		},
+		// Case 3
+		{
+			"Mozilla/5.0 (Windows NT 6.3; Trident/7.0; rv:11.0) like Gecko",
+			ieFormats,
+		},
+		// Case 4
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 " +
+				"(KHTML, like Gecko) Chrome/52.0.2743.116 Safari/537.36 " +
+				"Edge/15.15063",
+			woff2Formats,
+		},
+		// Case 5
+		{
+			"Mozilla/5.0 (Windows NT 6.1; rv:10.0) Gecko/20100101 Firefox/10.0",
+			woffFormats,
+		},
+		// Case 6
+		{
+			"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 " +
+				"Firefox/120.0",
+			woff2Formats,
+		},
+		// Case 7
+		{
+			"Mozilla/5.0 (Windows NT 6.1) AppleWebKit/537.36 " +
+				"(KHTML, like Gecko) Chrome/30.0.1599.101 Safari/537.36",
+			woffFormats,
+		},
+		// Case 8
+		{
+			"Mozilla/5.0 (Linux; U; Android 4.0.3; en-us) AppleWebKit/534.30 " +
+				"(KHTML, like Gecko) Version/4.0 Mobile Safari/534.30",
+			ttfFormats,
+		},
+		// Case 9
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_6) " +
+				"AppleWebKit/603.3.8 (KHTML, like Gecko) Version/10.1.2 " +
+				"Safari/603.3.8",
+			woff2Formats,
+		},
+		// Case 10
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_7_5) " +
+				"AppleWebKit/536.26.17 (KHTML, like Gecko) Version/6.0.2 " +
+				"Safari/536.26.17",
+			woffFormats,
+		},
+		// Case 11
+		{
+			"curl/7.88.1",
+			defaultFormats,
+		},
+		// Case 12
+		{
+			"",
+			defaultFormats,
+		},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		gFormats := AgentFormats(c.UserAgent)
+		test.VerifyFatal(t, 1, j, len(c.Formats), len(gFormats))
+		for k, wFormat := range c.Formats {
+			test.Verify(t, 2, j, wFormat, gFormats[k])
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Encodings holds the content codings applied by MakeCompressHandler, in order
+// of preference.
+var Encodings = []string{"br", "gzip"}
+
+// encoder is a writer compressing the data written to it.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressResponseWriter compresses the response body as it is written,
+// streaming it to the underlying http.ResponseWriter. The content coding is
+// applied only to successful responses with a body, so the status of those is
+// held back until the first data is written, or the response is flushed.
+type compressResponseWriter struct {
+	encoder  encoder
+	encoding string
+	skip     bool // Whether the response is sent as is.
+	status   int
+	http.ResponseWriter
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if !bodyAllowed(status) || status >= http.StatusBadRequest ||
+		w.Header().Get("Content-Encoding") != "" {
+		w.skip = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.skip {
+		return w.ResponseWriter.Write(b)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if w.encoder == nil {
+		w.start(b)
+	}
+	return w.encoder.Write(b)
+}
+
+// Flush sends the data compressed so far to the client.
+func (w *compressResponseWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.skip {
+		if w.encoder == nil {
+			w.start(nil)
+		}
+		w.encoder.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start writes the held back status along with the Content-Encoding header,
+// and creates the encoder. The content type is sniffed from the given
+// uncompressed data if it is not set.
+func (w *compressResponseWriter) start(b []byte) {
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(b) > 0 {
+		h.Set("Content-Type", http.DetectContentType(b))
+	}
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.encoder = newEncoder(w.encoding, w.ResponseWriter)
+}
+
+// close completes the compressed stream, or writes the held back status of a
+// response without a body.
+func (w *compressResponseWriter) close() error {
+	switch {
+	case w.encoder != nil:
+		return w.encoder.Close()
+	case w.status != 0 && !w.skip:
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	return nil
+}
+
+// bodyAllowed reports whether a response with the given status may include a
+// body, see RFC 7230, section 3.3.
+func bodyAllowed(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent:
+		return false
+	case status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// MakeCompressHandler is a http handler wrapper which compresses the response
+// body generated by the wrapped http handler, using the content coding from
+// Encodings most acceptable to the client, as negotiated by NegotiateEncoding.
+// The body is compressed as it is written, without being buffered. Responses
+// without a body, such as 304 Not Modified ones, and error responses are sent
+// as is.
+func MakeCompressHandler(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoding := NegotiateEncoding(r.Header.Get("Accept-Encoding"),
+			Encodings)
+		if encoding == "" {
+			fn(w, r)
+			return
+		}
+		// The body of the responses to HEAD requests is
+		// compressed as well, and then discarded by the
+		// server, so that their headers match those of
+		// the responses to GET requests.
+		cw := &compressResponseWriter{
+			encoding:       encoding,
+			ResponseWriter: w,
+		}
+		defer cw.close()
+		fn(cw, r)
+	}
+}
+
+// newEncoder returns an encoder compressing the data written to it using the
+// given content coding, one of Encodings, and writing it to w.
+func newEncoder(encoding string, w io.Writer) encoder {
+	if encoding == "br" {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	}
+	return gzip.NewWriter(w)
+}
+
+// NegotiateEncoding returns the content coding from the given ones, ordered
+// by preference, which is the most acceptable according to the given value of
+// the Accept-Encoding HTTP request header, or an empty string if none of them
+// is acceptable, or if the client prefers the identity coding. The quality
+// values of the header are taken into account, codings with a zero quality
+// value are not acceptable, and "*" matches the codings not listed.
+func NegotiateEncoding(header string, encodings []string) string {
+	qvalues := parseAcceptEncoding(header)
+	var best string
+	var bestQ float64
+	for _, encoding := range encodings {
+		q, ok := qvalues[encoding]
+		if !ok && encoding == "gzip" {
+			q, ok = qvalues["x-gzip"]
+		}
+		if !ok {
+			q, ok = qvalues["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	if q, ok := qvalues["identity"]; ok && q > bestQ {
+		return ""
+	}
+	return best
+}
+
+// parseAcceptEncoding parses the given value of the Accept-Encoding HTTP
+// request header and returns the quality value of each content coding, in
+// lower case. Codings with an invalid quality value are left out.
+func parseAcceptEncoding(header string) map[string]float64 {
+	qvalues := make(map[string]float64)
+	for _, element := range strings.Split(header, ",") {
+		params := strings.Split(element, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) < 2 || strings.ToLower(param[:2]) != "q=" {
+				continue
+			}
+			var err error
+			q, err = strconv.ParseFloat(param[2:], 64)
+			if err != nil || q < 0 || q > 1 {
+				q = -1
+			}
+		}
+		if q >= 0 {
+			qvalues[coding] = q
+		}
+	}
+	return qvalues
+}
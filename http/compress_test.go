@@ -0,0 +1,206 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/noll/mjau/test"
+)
+
+func TestMakeCompressHandler(t *testing.T) {
+	handler := MakeCompressHandler(helloHandler)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var cases = []struct {
+		AcceptEncoding  string
+		ContentEncoding string
+	}{
+		// Case 1
+		{"gzip", "gzip"},
+		// Case 2
+		{"gzip, deflate, br", "br"},
+		// Case 3
+		{"gzip, br;q=0.5", "gzip"},
+		// Case 4
+		{"br;q=0, gzip;q=0.5", "gzip"},
+		// Case 5
+		{"identity", ""},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		// Disable the transparent decompression of
+		// the client, so that the body is received
+		// as sent.
+		client := http.Client{
+			Transport: &http.Transport{DisableCompression: true},
+		}
+		req, err := http.NewRequest("GET", server.URL, nil)
+		test.VerifyFatal(t, 1, j, true, nil == err)
+		req.Header.Set("Accept-Encoding", c.AcceptEncoding)
+
+		resp, err := client.Do(req)
+		test.VerifyFatal(t, 2, j, true, nil == err)
+		defer resp.Body.Close()
+		gContentEncoding := resp.Header.Get("Content-Encoding")
+		test.Verify(t, 3, j, c.ContentEncoding, gContentEncoding)
+
+		var body io.Reader = resp.Body
+		switch gContentEncoding {
+		case "br":
+			body = brotli.NewReader(resp.Body)
+		case "gzip":
+			body, err = gzip.NewReader(resp.Body)
+			test.VerifyFatal(t, 4, j, true, nil == err)
+		}
+		gBody, err := ioutil.ReadAll(body)
+		test.VerifyFatal(t, 6, j, true, nil == err)
+		test.Verify(t, 7, j, "Hej!", string(gBody))
+	}
+
+	// Compare with the gzip encoder.
+	buf := new(bytes.Buffer)
+	gzipWriter := gzip.NewWriter(buf)
+	fmt.Fprint(gzipWriter, "Hej!")
+	err := gzipWriter.Close()
+	test.VerifyFatal(t, 8, 0, true, nil == err)
+	req, err := http.NewRequest("GET", "/", nil)
+	test.VerifyFatal(t, 9, 0, true, nil == err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	test.Verify(t, 10, 0, true, bytes.Equal(buf.Bytes(), w.Body.Bytes()))
+}
+
+func TestMakeCompressHandlerStatus(t *testing.T) {
+	var cases = []struct {
+		Method          string
+		Status          int
+		Body            string
+		ContentEncoding string
+	}{
+		// Case 1
+		{"GET", http.StatusOK, "Hej!", "gzip"},
+		// Case 2
+		{"GET", http.StatusOK, "", ""},
+		// Case 3
+		{"HEAD", http.StatusOK, "Hej!", "gzip"},
+		// Case 4
+		{"GET", http.StatusNoContent, "", ""},
+		// Case 5
+		{"GET", http.StatusNotModified, "", ""},
+		// Case 6
+		{"GET", http.StatusForbidden, "Forbidden", ""},
+		// Case 7
+		{"GET", http.StatusInternalServerError, "Internal Server Error", ""},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		handler := MakeCompressHandler(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.Status)
+			fmt.Fprint(w, c.Body)
+		})
+		req, err := http.NewRequest(c.Method, "/", nil)
+		test.VerifyFatal(t, 1, j, true, nil == err)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		test.Verify(t, 2, j, c.Status, w.Code)
+		test.Verify(t, 3, j, c.ContentEncoding, w.Header().Get("Content-Encoding"))
+		if c.ContentEncoding == "" {
+			test.Verify(t, 4, j, c.Body, w.Body.String())
+		}
+	}
+}
+
+func TestMakeCompressHandlerFlush(t *testing.T) {
+	w := httptest.NewRecorder()
+	handler := MakeCompressHandler(func(cw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(cw, "Hej!")
+		cw.(http.Flusher).Flush()
+		test.Verify(t, 1, 0, true, w.Flushed)
+
+		// The data written so far is sent to the client
+		// and can be decompressed.
+		gzipReader, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+		test.VerifyFatal(t, 2, 0, true, nil == err)
+		b := make([]byte, 4)
+		_, err = io.ReadFull(gzipReader, b)
+		test.VerifyFatal(t, 3, 0, true, nil == err)
+		test.Verify(t, 4, 0, "Hej!", string(b))
+		fmt.Fprint(cw, " Hej!")
+	})
+	req, err := http.NewRequest("GET", "/", nil)
+	test.VerifyFatal(t, 5, 0, true, nil == err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler(w, req)
+	test.Verify(t, 6, 0, "gzip", w.Header().Get("Content-Encoding"))
+	gzipReader, err := gzip.NewReader(w.Body)
+	test.VerifyFatal(t, 7, 0, true, nil == err)
+	body, err := ioutil.ReadAll(gzipReader)
+	test.VerifyFatal(t, 8, 0, true, nil == err)
+	test.Verify(t, 9, 0, "Hej! Hej!", string(body))
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	var cases = []struct {
+		AcceptEncoding string
+		Encoding       string
+	}{
+		// Case 1
+		{"", ""},
+		// Case 2
+		{"gzip", "gzip"},
+		// Case 3
+		{"gzip;q=0", ""},
+		// Case 4
+		{"GZIP ; Q=0.5, deflate", "gzip"},
+		// Case 5
+		{"gzip, deflate, br", "br"},
+		// Case 6
+		{"br;q=0.5, gzip;q=0.8", "gzip"},
+		// Case 7
+		{"br;q=0.8, gzip;q=0.8", "br"},
+		// Case 8
+		{"zstd, gzip", "gzip"},
+		// Case 9
+		{"*", "br"},
+		// Case 10
+		{"*;q=0.5, br;q=0", "gzip"},
+		// Case 11
+		{"*;q=0", ""},
+		// Case 12
+		{"x-gzip", "gzip"},
+		// Case 13
+		{"identity;q=1, gzip;q=0.5", ""},
+		// Case 14
+		{"identity;q=0.5, gzip", "gzip"},
+		// Case 15
+		{"br;q=2, gzip;q=abc, x-gzip;q=0.001", "gzip"},
+		// Case 16
+		{" , ;q=1, deflate", ""},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		got := NegotiateEncoding(c.AcceptEncoding, Encodings)
+		test.Verify(t, 1, j, c.Encoding, got)
+	}
+}
+
+func helloHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "Hej!")
+}
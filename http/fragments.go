@@ -0,0 +1,171 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/noll/mjau/font"
+	"github.com/noll/mjau/inventory"
+)
+
+// Fragments holds the parts of the CSS files precomputed from the fonts of a
+// font inventory, including their subsets: the embedded sources of the font
+// files, and the @font-face rules of the font faces made of a single font,
+// embedded and linked, rendered using the template of its font format and the
+// template of all the font formats. The CSS files are then assembled by
+// concatenating the rules, instead of reading the font files, encoding them,
+// and executing the templates on each request.
+//
+// The rules are rendered separately only if the templates allow it, that is if
+// the output of a template for several font faces is the output for no font
+// face followed by the rules of each font face, as for the default templates.
+type Fragments struct {
+	digest   string            // Digest of the templates.
+	fontURL  string            // Base URL of linked font files.
+	prefixes map[string][]byte // Output of the templates for no font face.
+	rules    map[ruleKey][]byte
+	sources  map[*font.Font]*Source
+}
+
+type ruleKey struct {
+	font *font.Font
+	link bool
+	name string // Name of the template.
+}
+
+// NewFragments precomputes the fragments of the fonts of the given font
+// inventory using the given templates and base URL of linked font files.
+// The fonts whose files cannot be read are left out.
+func NewFragments(inv *inventory.Inventory, t *template.Template,
+	fontURL string) *Fragments {
+	fr := &Fragments{
+		digest:   TemplatesDigest(t),
+		fontURL:  fontURL,
+		prefixes: make(map[string][]byte),
+		rules:    make(map[ruleKey][]byte),
+		sources:  make(map[*font.Font]*Source),
+	}
+	for _, name := range TemplateNames() {
+		if prefix, ok := templatePrefix(t, name); ok {
+			fr.prefixes[name] = prefix
+		}
+	}
+	for _, fnt := range inv.Fonts() {
+		fr.add(fnt, t)
+		for _, subset := range fnt.Subsets {
+			fr.add(subset, t)
+		}
+	}
+	return fr
+}
+
+// add precomputes the fragments of the given font.
+func (fr *Fragments) add(f *font.Font, t *template.Template) {
+	data, err := Subset(f, "", HandlerContext{})
+	if err != nil {
+		return
+	}
+	source := new(Source)
+	if err := source.FromData(*f, data); err != nil {
+		return
+	}
+	fr.sources[f] = source
+	linked := new(Source)
+	if err := linked.FromFontURL(*f, FontURL(fr.fontURL, f)); err != nil {
+		return
+	}
+	for _, name := range []string{f.Format.String() + ".css.tmpl",
+		"all.css.tmpl"} {
+		prefix, ok := fr.prefixes[name]
+		if !ok {
+			continue
+		}
+		for link, s := range map[bool]*Source{false: source, true: linked} {
+			fontFace := new(FontFace)
+			fontFace.FromSources(*f, []*Source{s})
+			if rule, err := renderRule(t, name, prefix, fontFace); err == nil {
+				fr.rules[ruleKey{f, link, name}] = rule
+			}
+		}
+	}
+}
+
+// prefix returns the output of the named template for no font face and
+// reports whether the rules of the font faces may be rendered separately
+// using the templates of the given context.
+func (fr *Fragments) prefix(name string, ctx HandlerContext) ([]byte, bool) {
+	if fr == nil || !fr.match(ctx) {
+		return nil, false
+	}
+	prefix, ok := fr.prefixes[name]
+	return prefix, ok
+}
+
+// rule returns the precomputed @font-face rule of the font face made of the
+// given font, rendered using the named template, or nil if it has not been
+// precomputed for the given context.
+func (fr *Fragments) rule(f *font.Font, link bool, name string,
+	ctx HandlerContext) []byte {
+	if fr == nil || !fr.match(ctx) {
+		return nil
+	}
+	return fr.rules[ruleKey{f, link, name}]
+}
+
+// source returns the precomputed embedded source of the given font, or nil if
+// it has not been precomputed.
+func (fr *Fragments) source(f *font.Font) *Source {
+	if fr == nil {
+		return nil
+	}
+	return fr.sources[f]
+}
+
+// match reports whether the fragments were precomputed using the templates
+// and the base URL of linked font files of the given context.
+func (fr *Fragments) match(ctx HandlerContext) bool {
+	return fr.digest == ctx.TemplatesDigest && fr.fontURL == ctx.Flags.FontURL
+}
+
+// renderRule renders the @font-face rule of the given font face using the
+// named template, whose output for no font face is the given prefix.
+func renderRule(t *template.Template, name string, prefix []byte,
+	fontFace *FontFace) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := t.ExecuteTemplate(buf, name, []*FontFace{fontFace}); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(buf.Bytes(), prefix) {
+		// Should not happen, see templatePrefix.
+		return nil, fmt.Errorf("template %s: unexpected output", name)
+	}
+	return buf.Bytes()[len(prefix):], nil
+}
+
+// templatePrefix returns the output of the named template for no font face
+// and reports whether the rules of the font faces may be rendered separately
+// using the template, checking it against sample font faces.
+func templatePrefix(t *template.Template, name string) ([]byte, bool) {
+	samples := sampleFontFaces()
+	faces := samples[len(samples)-1]
+	var outputs [][]byte
+	for _, data := range [][]*FontFace{nil, faces[:1], faces[1:], faces} {
+		buf := new(bytes.Buffer)
+		if err := t.ExecuteTemplate(buf, name, data); err != nil {
+			return nil, false
+		}
+		outputs = append(outputs, buf.Bytes())
+	}
+	prefix, first, second, both := outputs[0], outputs[1], outputs[2], outputs[3]
+	if !bytes.HasPrefix(first, prefix) || !bytes.HasPrefix(second, prefix) {
+		return nil, false
+	}
+	concat := append(append(append([]byte{}, prefix...),
+		first[len(prefix):]...), second[len(prefix):]...)
+	return prefix, bytes.Equal(both, concat)
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/noll/mjau/font"
+	"github.com/noll/mjau/inventory"
+	"github.com/noll/mjau/test"
+)
+
+func TestNewFragments(t *testing.T) {
+	inv := inventory.New()
+	err := inv.Build(fl)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	var filenames []string
+	for _, name := range TemplateNames() {
+		filenames = append(filenames, filepath.Join(tp, name))
+	}
+	tmpl, err := template.ParseFiles(filenames...)
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+	ctx := HandlerContext{
+		Flags:           Flags{FontURL: "/font/"},
+		Inventory:       *inv,
+		Templates:       *tmpl,
+		TemplatesDigest: TemplatesDigest(tmpl),
+	}
+	fr := NewFragments(inv, tmpl, "/font/")
+	fonts := inv.Fonts()
+	test.Verify(t, 3, 0, len(fonts), len(fr.sources))
+	test.Verify(t, 4, 0, 4*len(fonts), len(fr.rules))
+	test.Verify(t, 5, 0, len(TemplateNames()), len(fr.prefixes))
+
+	woff := inv.QueryAll(inventory.Query{RowKey: "Amaranth", ColumnKey: "400normal"})
+	test.VerifyFatal(t, 6, 0, 2, len(woff))
+	italic := inv.QueryAll(inventory.Query{RowKey: "Amaranth", ColumnKey: "400italic"})
+	test.VerifyFatal(t, 7, 0, 2, len(italic))
+
+	var cases = []struct {
+		FaceFonts [][]*font.Font
+		Text      string
+		Link      bool
+		Name      string
+	}{
+		// Case 1
+		{[][]*font.Font{woff[1:]}, "", false, "woff.css.tmpl"},
+		// Case 2
+		{[][]*font.Font{woff[1:], italic[1:]}, "", true, "woff.css.tmpl"},
+		// Case 3
+		{[][]*font.Font{woff[:1], italic[:1]}, "", false, "eot.css.tmpl"},
+		// Case 4
+		{[][]*font.Font{woff, italic}, "", false, "all.css.tmpl"},
+		// Case 5
+		{[][]*font.Font{woff[1:]}, "Hi", false, "woff.css.tmpl"},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		want, err := RenderCss(c.FaceFonts, c.Text, c.Link, c.Name, ctx)
+		test.VerifyFatal(t, 1, j, true, nil == err)
+		fCtx := ctx
+		fCtx.Fragments = fr
+		got, err := RenderCss(c.FaceFonts, c.Text, c.Link, c.Name, fCtx)
+		test.VerifyFatal(t, 2, j, true, nil == err)
+		test.Verify(t, 3, j, string(want), string(got))
+	}
+
+	// Fragments precomputed from other templates
+	// or another base URL are not used.
+	other := ctx
+	other.Fragments = fr
+	other.TemplatesDigest = ""
+	test.Verify(t, 8, 0, true,
+		nil == other.Fragments.rule(woff[1], false, "woff.css.tmpl", other))
+	other.TemplatesDigest = ctx.TemplatesDigest
+	other.Flags.FontURL = "/other/"
+	test.Verify(t, 9, 0, true,
+		nil == other.Fragments.rule(woff[1], true, "woff.css.tmpl", other))
+	other.Flags.FontURL = ctx.Flags.FontURL
+	test.Verify(t, 10, 0, false,
+		nil == other.Fragments.rule(woff[1], true, "woff.css.tmpl", other))
+}
+
+func TestFragmentsTemplateName(t *testing.T) {
+	inv := inventory.New()
+	err := inv.Build(fl)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	var filenames []string
+	for _, name := range TemplateNames() {
+		filenames = append(filenames, filepath.Join(tp, name))
+	}
+	tmpl, err := template.ParseFiles(filenames...)
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+	// Make the template of all the font formats differ
+	// from the templates of each font format.
+	_, err = tmpl.New("all.css.tmpl").Parse(
+		"{{range .}}/* all */ {{.Family}} {{.Format}}\n{{end}}")
+	test.VerifyFatal(t, 3, 0, true, nil == err)
+	ctx := HandlerContext{
+		Flags:           Flags{FontURL: "/font/"},
+		Inventory:       *inv,
+		Templates:       *tmpl,
+		TemplatesDigest: TemplatesDigest(tmpl),
+	}
+	woff := inv.QueryAll(inventory.Query{RowKey: "Amaranth", ColumnKey: "400normal"})
+	test.VerifyFatal(t, 4, 0, 2, len(woff))
+
+	fCtx := ctx
+	fCtx.Fragments = NewFragments(inv, tmpl, "/font/")
+	for i, name := range []string{"all.css.tmpl", "woff.css.tmpl"} {
+		j := i + 1
+		for _, link := range []bool{false, true} {
+			faceFonts := [][]*font.Font{woff[1:]}
+			want, err := RenderCss(faceFonts, "", link, name, ctx)
+			test.VerifyFatal(t, 5, j, true, nil == err)
+			got, err := RenderCss(faceFonts, "", link, name, fCtx)
+			test.VerifyFatal(t, 6, j, true, nil == err)
+			test.Verify(t, 7, j, string(want), string(got))
+			test.Verify(t, 8, j, name == "all.css.tmpl",
+				strings.Contains(string(got), "/* all */"))
+		}
+	}
+}
+
+func TestTemplatePrefix(t *testing.T) {
+	var cases = []struct {
+		Text   string
+		Prefix string
+		Ok     bool
+	}{
+		// Case 1
+		{"header\n{{range .}}{{.Family}}\n{{end}}", "header\n", true},
+		// Case 2
+		{"{{range .}}{{.Family}}\n{{end}}footer\n", "", false},
+		// Case 3
+		{"{{len .}}{{range .}}{{.Family}}\n{{end}}", "", false},
+		// Case 4
+		{"{{range .}}{{.Nope}}{{end}}", "", false},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		tmpl, err := template.New("woff.css.tmpl").Parse(c.Text)
+		test.VerifyFatal(t, 1, j, true, nil == err)
+		prefix, ok := templatePrefix(tmpl, "woff.css.tmpl")
+		test.Verify(t, 2, j, c.Ok, ok)
+		if ok {
+			test.Verify(t, 3, j, c.Prefix, string(prefix))
+		}
+	}
+}
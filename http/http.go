@@ -8,211 +8,961 @@ package http
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
+	"unicode/utf8"
 
+	"github.com/noll/mjau/cache"
 	"github.com/noll/mjau/font"
 	"github.com/noll/mjau/inventory"
+	"github.com/noll/mjau/log"
 	"github.com/noll/mjau/util"
 	"github.com/noll/mjau/whitelist"
 )
 
+const (
+	// AllowedMethods lists the methods allowed by the CSS and font handlers,
+	// as sent using the Allow HTTP response header.
+	AllowedMethods = "GET, HEAD, OPTIONS"
+	// AllowedHeaders lists the request headers which may be used in
+	// cross-origin requests, beside the CORS-safelisted ones.
+	AllowedHeaders = "If-Modified-Since, If-None-Match, Range"
+	// PreflightMaxAge is the number of seconds the results of a CORS
+	// preflight request may be cached.
+	PreflightMaxAge = 86400
+)
+
 // Flags holds the state of the HTTP flags.
 type Flags struct {
-	AcAllowOrigin bool   // Cross-origin resource sharing toggle.
-	CcMaxAge      uint64 // Cache-Control max-age value.
-	Etag          bool   // Entity tags validation toggle.
-	Gzip          bool   // Response gzip compression toggle.
-	Version       string // Server version string.
+	AcAllowOrigin bool     // Cross-origin resource sharing toggle.
+	AcOrigins     []string // Origins allowed by CORS, the whitelist if empty.
+	CcMaxAge      uint64   // Cache-Control max-age value.
+	Compress      bool     // Response compression toggle.
+	Etag          bool     // Entity tags validation toggle.
+	FontURL       string   // Base URL of linked font files.
+	Link          bool     // Font files linking toggle.
+	Nearest       bool     // Nearest font matching toggle.
+	Version       string   // Server version string.
 }
 
-// FontFace represents a single @font-face CSS rule.
+// FontFace represents a single @font-face CSS rule. The Base64Data, Format,
+// IefixURL, MimeType, and URL fields describe the first of the font face
+// sources. The Subset and UnicodeRange fields are set only if the font face is
+// a subset. The Stretch and WeightRange fields are set only if the font face
+// is a variable font, as the font-stretch and font-weight descriptor ranges.
 type FontFace struct {
-	Base64Data string
-	Family     string
-	Format     string
-	MimeType   string
-	Style      string
-	Weight     int
+	Base64Data   string
+	Family       string
+	Format       string
+	IefixURL     string
+	MimeType     string
+	Sources      []*Source
+	Stretch      string
+	Style        string
+	Subset       string
+	UnicodeRange string
+	URL          string
+	Weight       int
+	WeightRange  string
 }
 
-// HandlerContext represents a context for a handler.
+// HandlerContext represents a context for a handler. If Resources is not nil,
+// the reloadable resources it holds replace the ones of the context for each
+// request.
 type HandlerContext struct {
-	Flags     Flags
-	Inventory inventory.Inventory
-	Templates template.Template
-	Whitelist whitelist.Whitelist
+	Flags            Flags
+	Fragments        *Fragments // Precomputed CSS fragments, nil if disabled.
+	Inventory        inventory.Inventory
+	Log              *log.Logger  // Logger, nil if disabled.
+	Resources        *Resources   // Reloadable resources, nil if disabled.
+	Responses        *cache.Cache // Rendered CSS cache, nil if disabled.
+	Subsets          *cache.Cache // Font subsets cache, nil if disabled.
+	Templates        template.Template
+	TemplatesDigest  string    // Digest of the templates, see TemplatesDigest.
+	TemplatesModTime time.Time // Modification time of the templates.
+	Whitelist        whitelist.Whitelist
 }
 
 type HandlerFunc func(http.ResponseWriter, *http.Request, HandlerContext)
 
+// Source represents a single font file referenced by the src descriptor of a
+// @font-face CSS rule. The font file is either embedded using the base64 data
+// or linked using the URL. The IefixURL is the URL followed by the "#iefix"
+// fragment, after an empty query if the URL has none, as used to link EOT font
+// files for old versions of Internet Explorer.
+type Source struct {
+	Base64Data string
+	CssFormat  string
+	Format     string
+	IefixURL   string
+	MimeType   string
+	URL        string
+}
+
 // FromFont initializes ff from the given font. If it is called on an already
 // initialized font face, changes the font face according to the given font.
 // Returns an error if the initialization fails.
 func (ff *FontFace) FromFont(f font.Font) error {
+	source := new(Source)
+	if err := source.FromFont(f); err != nil {
+		return err
+	}
+	ff.FromSources(f, []*Source{source})
+	return nil
+}
+
+// FromFontURL initializes ff from the given font, which is going to be linked
+// through the given URL instead of being embedded. If it is called on an
+// already initialized font face, changes the font face according to the given
+// font. Returns an error if the initialization fails.
+func (ff *FontFace) FromFontURL(f font.Font, url string) error {
+	source := new(Source)
+	if err := source.FromFontURL(f, url); err != nil {
+		return err
+	}
+	ff.FromSources(f, []*Source{source})
+	return nil
+}
+
+// FromSources initializes ff from the family, style, and weight of the given
+// font and from the given non-empty list of sources. If it is called on an
+// already initialized font face, changes the font face accordingly.
+func (ff *FontFace) FromSources(f font.Font, sources []*Source) {
+	ff.Base64Data = sources[0].Base64Data
+	ff.Family = f.Family
+	ff.Format = sources[0].Format
+	ff.IefixURL = sources[0].IefixURL
+	ff.MimeType = sources[0].MimeType
+	ff.Sources = sources
+	ff.Stretch = ""
+	ff.Style = f.Style
+	ff.Subset = f.SubsetName
+	ff.UnicodeRange = f.UnicodeRange
+	ff.URL = sources[0].URL
+	ff.Weight = f.Weight
+	ff.WeightRange = ""
+	if a := f.Axis("wght"); a != nil {
+		ff.WeightRange = fmt.Sprintf("%g %g", a.Min, a.Max)
+	}
+	if a := f.Axis("wdth"); a != nil {
+		ff.Stretch = fmt.Sprintf("%g%% %g%%", a.Min, a.Max)
+	}
+	if a := f.Axis("slnt"); a != nil && f.Style != "italic" {
+		// Positive slant values lean to the left, unlike
+		// oblique angles. Subtracting from 0 avoids -0.
+		ff.Style = fmt.Sprintf("oblique %gdeg %gdeg", 0-a.Max, 0-a.Min)
+	}
+}
+
+// FromFont initializes s from the given font. If it is called on an already
+// initialized source, changes the source according to the given font.
+// Returns an error if the initialization fails.
+func (s *Source) FromFont(f font.Font) error {
 	data, err := f.Contents()
 	if err != nil {
 		return err
 	}
+	return s.FromData(f, data)
+}
+
+// FromData initializes s from the given font and the given font file contents,
+// which are embedded instead of the contents of the font file, e.g. a subset of
+// the font file. If it is called on an already initialized source, changes the
+// source accordingly. Returns an error if the initialization fails.
+func (s *Source) FromData(f font.Font, data []byte) error {
 	mimeType := f.MimeType()
 	if mimeType == "" {
 		// Should not happen.
 		return fmt.Errorf("can't determine font MIME type")
 	}
-	ff.Base64Data = util.Base64(data)
-	ff.Family = f.Family
-	ff.Format = f.Format.String()
-	ff.MimeType = mimeType
-	ff.Style = f.Style
-	ff.Weight = f.Weight
+	s.Base64Data = util.Base64(data)
+	s.CssFormat = f.Format.CssFormat()
+	s.Format = f.Format.String()
+	s.IefixURL = ""
+	s.MimeType = mimeType
+	s.URL = ""
+	return nil
+}
+
+// FromFontURL initializes s from the given font, which is going to be linked
+// through the given URL instead of being embedded. If it is called on an
+// already initialized source, changes the source according to the given font.
+// Returns an error if the initialization fails.
+func (s *Source) FromFontURL(f font.Font, url string) error {
+	mimeType := f.MimeType()
+	if mimeType == "" {
+		// Should not happen.
+		return fmt.Errorf("can't determine font MIME type")
+	}
+	s.Base64Data = ""
+	s.CssFormat = f.Format.CssFormat()
+	s.Format = f.Format.String()
+	s.IefixURL = url + "?#iefix"
+	if strings.Contains(url, "?") {
+		s.IefixURL = url + "#iefix"
+	}
+	s.MimeType = mimeType
+	s.URL = url
 	return nil
 }
 
 func CssHandler(w http.ResponseWriter, r *http.Request, ctx HandlerContext) {
-	if r.Method != "GET" {
-		// TODO: Add logging.
-		NotImplemented(w, r)
+	rLog := RequestLog(r, ctx)
+	if !AllowMethod(w, r, ctx) {
 		return
 	}
 	// Allow only whitelisted referers to fetch the resource.
 	if !ctx.Whitelist.Contains(r.Referer()) {
-		// TODO: Add logging.
+		rLog.Info("referer not whitelisted")
 		Forbidden(w, r)
 		return
 	}
 	family := r.FormValue("family")
 	if family == "" {
-		// TODO: Add logging.
+		rLog.Info("font family not specified")
 		BadRequest(w, r)
 		return
 	}
 	sFormat := r.FormValue("format")
-	if sFormat == "" {
-		// Font format not specified,
-		// default to WOFF.
-		sFormat = "woff"
-	}
+	// All the available font formats are delivered
+	// together when the "all" format is requested.
+	all := sFormat == "all"
+	// Font format not specified, negotiate it
+	// using the User-Agent HTTP request header.
+	negotiate := sFormat == ""
 	format := font.NOF
 	format.FromString(sFormat)
-	if format == font.NOF {
-		// TODO: Add logging.
+	if format == font.NOF && !all && !negotiate {
+		rLog.Info("unknown font format", "family", family,
+			"format", sFormat)
 		BadRequest(w, r)
 		return
 	}
+	var agentFormats []font.Format
+	if negotiate {
+		agentFormats = AgentFormats(r.UserAgent())
+		w.Header().Add("Vary", "User-Agent")
+	}
+	link := ctx.Flags.Link
+	switch r.FormValue("delivery") {
+	case "":
+		// Delivery method not specified,
+		// default to the link flag.
+	case "inline":
+		link = false
+	case "link":
+		link = true
+	default:
+		rLog.Info("unknown delivery method", "family", family,
+			"delivery", r.FormValue("delivery"))
+		BadRequest(w, r)
+		return
+	}
+	// Only the characters of the text are needed,
+	// deliver subsets of the font files.
+	text := NormalizeText(r.FormValue("text"))
+	// Only the named subsets are needed, if any.
+	var subsets []string
+	if subset := r.FormValue("subset"); subset != "" {
+		subsets = strings.Split(subset, ",")
+	}
 	queries := Queries(family, format)
 	if len(queries) == 0 {
-		// TODO: Add logging.
+		rLog.Info("invalid font family", "family", family)
 		BadRequest(w, r)
 		return
 	}
-	if ctx.Flags.Etag && Etag(w, r, queries, ctx) {
-		return
+	// Each query is resolved to the fonts
+	// used as sources of one font face.
+	var faceFonts [][]*font.Font
+	var fonts []*font.Font
+	lookup := func(query inventory.Query) []*font.Font {
+		switch {
+		case all:
+			return ctx.Inventory.QueryAll(query)
+		case negotiate:
+			fnt := ctx.Inventory.QueryPreferred(query, agentFormats)
+			if fnt != nil {
+				return []*font.Font{fnt}
+			}
+		default:
+			if fnt := ctx.Inventory.Query(query); fnt != nil {
+				return []*font.Font{fnt}
+			}
+		}
+		return nil
 	}
-	var templateData []*FontFace
-	var templateName string
+	// The font formats considered when looking
+	// for the nearest matches of the queries.
+	nearestFormats := []font.Format{format}
+	switch {
+	case all:
+		nearestFormats = font.Formats
+	case negotiate:
+		nearestFormats = agentFormats
+	}
+	// Substitutions made when looking for the nearest matches
+	// of the queries, reported in comments of the CSS file.
+	var substitutions []string
 	for _, query := range queries {
-		fnt := ctx.Inventory.Query(*query)
-		if fnt == nil {
-			// TODO: Add logging.
+		qFonts := lookup(*query)
+		if len(qFonts) == 0 && ctx.Flags.Nearest {
+			nQuery, ok := ctx.Inventory.Nearest(*query, nearestFormats)
+			requested := query.RowKey + ":" +
+				strings.TrimPrefix(query.ColumnKey, format.String())
+			if !ok {
+				// Skip the font face instead of failing
+				// the whole CSS file.
+				rLog.Debug("font not available, skipped",
+					"family", query.RowKey, "query", requested)
+				substitutions = append(substitutions,
+					requested+" is not available")
+				continue
+			}
+			rLog.Debug("font not available, substituted",
+				"family", query.RowKey, "query", requested,
+				"substitute", nQuery.ColumnKey)
+			substitutions = append(substitutions, requested+
+				" is not available, substituted by "+nQuery.ColumnKey)
+			nQuery.ColumnKey = format.String() + nQuery.ColumnKey
+			qFonts = lookup(nQuery)
+		}
+		if len(qFonts) == 0 {
+			rLog.Info("font not available", "family", query.RowKey,
+				"query", query.ColumnKey)
 			BadRequest(w, r)
 			return
 		}
-		fontFace := new(FontFace)
-		err := fontFace.FromFont(*fnt)
+		if text != "" {
+			// The text subsets replace the named subsets.
+			faceFonts = append(faceFonts, qFonts)
+			fonts = append(fonts, qFonts...)
+			continue
+		}
+		for _, sFonts := range SplitSubsets(qFonts, subsets) {
+			faceFonts = append(faceFonts, sFonts)
+			fonts = append(fonts, sFonts...)
+		}
+	}
+	if len(fonts) == 0 {
+		// None of the queries has a nearest match.
+		rLog.Info("no font available", "family", family)
+		BadRequest(w, r)
+		return
+	}
+	if negotiate {
+		// Use the template of the negotiated font format,
+		// unless the subfamilies were resolved to different
+		// font formats.
+		format = fonts[0].Format
+		for _, fnt := range fonts {
+			if fnt.Format != format {
+				all = true
+			}
+		}
+	}
+	templateName := format.String() + ".css.tmpl"
+	if all {
+		templateName = "all.css.tmpl"
+	}
+	// Look up the CSS file in the rendered CSS cache, unless
+	// the font files cannot be stat'ed.
+	var key string
+	var css []byte
+	if ctx.Responses != nil {
+		var err error
+		key, err = CssKey(faceFonts, text, link, templateName, ctx)
+		if err == nil {
+			css, _ = ctx.Responses.Get(key)
+		}
+	}
+	if css == nil {
+		var err error
+		css, err = RenderCss(faceFonts, text, link, templateName, ctx)
 		if err != nil {
-			// TODO: Add logging.
+			rLog.Error("CSS file not rendered", "family", family,
+				"template", templateName, "error", err)
 			InternalServerError(w, r)
 			return
 		}
-		templateData = append(templateData, fontFace)
-		templateName = fnt.Format.String() + ".css.tmpl"
+		if key != "" {
+			ctx.Responses.Put(key, css)
+		}
 	}
-	buf := new(bytes.Buffer)
-	err := ctx.Templates.ExecuteTemplate(buf, templateName, templateData)
-	if err != nil {
-		// TODO: Add logging.
-		InternalServerError(w, r)
+	body := css
+	if len(substitutions) > 0 {
+		buf := bytes.NewBuffer(append([]byte{}, css...))
+		for _, substitution := range substitutions {
+			// Make sure the comment can't be closed early.
+			substitution = strings.Replace(substitution, "*/", "* /", -1)
+			fmt.Fprintf(buf, "/* %s */\n", substitution)
+		}
+		body = buf.Bytes()
+	}
+	if ctx.Flags.Etag && Etag(w, r, body, ctx) {
+		return
+	}
+	if LastModified(w, r, LastModTime(fonts, ctx), ctx) {
 		return
 	}
 	maxAge := strconv.FormatUint(ctx.Flags.CcMaxAge, 10)
 	w.Header().Set("Cache-Control", "max-age="+maxAge)
 	w.Header().Set("Content-Type", "text/css; charset=utf-8")
-	io.Copy(w, buf)
+	w.Write(body)
 }
 
-// Etag generates and validates entity tags.
-// Returns true if the resource has not been modified.
-func Etag(w http.ResponseWriter, r *http.Request, queries []*inventory.Query,
-	ctx HandlerContext) bool {
-	var failed bool
-	hash := md5.New()
-	for _, query := range queries {
-		fnt := ctx.Inventory.Query(*query)
-		if fnt == nil {
-			// TODO: Log error.
-			failed = true
-			break
+// CssKey returns the key of the CSS file made of the given font faces in the
+// rendered CSS cache. The arguments are those of RenderCss. The key depends on
+// the modification times of the font files, the digest of the templates, and
+// the base URL of linked font files, so that CSS files embedding modified font
+// files or rendered using other templates are not reused, even if they were
+// added to the cache while the templates were reloaded. Returns an error if
+// any of the font files cannot be stat'ed.
+func CssKey(faceFonts [][]*font.Font, text string, link bool, name string,
+	ctx HandlerContext) (string, error) {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s\x00%s\x00%s\x00%t\x00%s", ctx.TemplatesDigest,
+		ctx.Flags.FontURL, name, link, text)
+	for _, fonts := range faceFonts {
+		buf.WriteString("\x00\x01")
+		for _, fnt := range fonts {
+			modtime, err := fnt.ModTime()
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(buf, "\x00%s\x00%d%s\x00%s\x00%s\x00%d",
+				fnt.Family, fnt.Weight, fnt.Style, fnt.Path, fnt.SubsetName,
+				modtime.UnixNano())
 		}
-		modtime, err := fnt.ModTime()
+	}
+	return buf.String(), nil
+}
+
+// RenderCss renders the CSS file made of the given font faces, each of them
+// described by the fonts used as its sources, using the named template. The
+// font files are subset to the given text unless it is empty, and linked
+// instead of being embedded if link is true. The precomputed fragments of the
+// given context are used, if any. Returns an error if any of the font files
+// cannot be read, or if the template fails to execute.
+func RenderCss(faceFonts [][]*font.Font, text string, link bool, name string,
+	ctx HandlerContext) ([]byte, error) {
+	prefix, ok := ctx.Fragments.prefix(name, ctx)
+	if !ok {
+		// The rules can't be rendered separately,
+		// execute the template on all of them.
+		var templateData []*FontFace
+		for _, qFonts := range faceFonts {
+			fontFace, err := NewFontFace(qFonts, text, link, ctx)
+			if err != nil {
+				return nil, err
+			}
+			templateData = append(templateData, fontFace)
+		}
+		buf := new(bytes.Buffer)
+		err := ctx.Templates.ExecuteTemplate(buf, name, templateData)
 		if err != nil {
-			// TODO: Log error.
-			failed = true
-			break
-		}
-		io.WriteString(hash, modtime.String())
-	}
-	if !failed {
-		etag := fmt.Sprintf("%x", hash.Sum(nil))
-		// Add "+gzip" suffix to entity tag if the response
-		// is going to be gzip compressed.
-		if ctx.Flags.Gzip {
-			acceptEncoding := r.Header.Get("Accept-Encoding")
-			if strings.Contains(acceptEncoding, "gzip") {
-				etag = etag + "+gzip"
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	// Look up the precomputed rules first, in order
+	// to allocate the CSS file at once.
+	rules := make([][]byte, len(faceFonts))
+	size := len(prefix)
+	for i, qFonts := range faceFonts {
+		if text == "" && len(qFonts) == 1 {
+			rules[i] = ctx.Fragments.rule(qFonts[0], link, name, ctx)
+			size += len(rules[i])
+		}
+	}
+	css := make([]byte, 0, size)
+	css = append(css, prefix...)
+	for i, qFonts := range faceFonts {
+		rule := rules[i]
+		if rule == nil {
+			fontFace, err := NewFontFace(qFonts, text, link, ctx)
+			if err != nil {
+				return nil, err
 			}
+			rule, err = renderRule(&ctx.Templates, name, prefix, fontFace)
+			if err != nil {
+				return nil, err
+			}
+		}
+		css = append(css, rule...)
+	}
+	return css, nil
+}
+
+// NewFontFace creates and returns the font face described by the given fonts,
+// used as its sources. The arguments are those of RenderCss. Returns an error
+// if any of the font files cannot be read.
+func NewFontFace(fonts []*font.Font, text string, link bool,
+	ctx HandlerContext) (*FontFace, error) {
+	var sources []*Source
+	for _, fnt := range fonts {
+		source := new(Source)
+		var err error
+		if link {
+			fontURL := FontURL(ctx.Flags.FontURL, fnt)
+			if text != "" {
+				fontURL += "?text=" + url.QueryEscape(text)
+			}
+			err = source.FromFontURL(*fnt, fontURL)
+		} else if s := ctx.Fragments.source(fnt); s != nil && text == "" {
+			source = s
+		} else {
+			var data []byte
+			data, err = Subset(fnt, text, ctx)
+			if err == nil {
+				err = source.FromData(*fnt, data)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	fontFace := new(FontFace)
+	fontFace.FromSources(*fonts[0], sources)
+	return fontFace, nil
+}
+
+// Etag generates and validates the entity tag of a response with the given
+// body. The entity tag is strong and derived from the body itself, so that it
+// changes along with the font files, the templates, and the flags the body
+// depends on. The content coding is appended to it if the response is going
+// to be compressed. Returns true if the resource has not been modified, in
+// which case a 304 Not Modified response has been sent.
+func Etag(w http.ResponseWriter, r *http.Request, body []byte,
+	ctx HandlerContext) bool {
+	sum := sha256.Sum256(body)
+	etag := fmt.Sprintf("%x", sum[:16])
+	// Add the content coding as suffix to entity tag,
+	// such as "+br" or "+gzip", if the response is going
+	// to be compressed.
+	if ctx.Flags.Compress {
+		acceptEncoding := r.Header.Get("Accept-Encoding")
+		encoding := NegotiateEncoding(acceptEncoding, Encodings)
+		if encoding != "" {
+			etag = etag + "+" + encoding
+		}
+	}
+	etag = `"` + etag + `"`
+	w.Header().Set("ETag", etag)
+	if MatchEtag(r.Header.Get("If-None-Match"), etag) {
+		maxAge := strconv.FormatUint(ctx.Flags.CcMaxAge, 10)
+		w.Header().Set("Cache-Control", "max-age="+maxAge)
+		NotModified(w, r)
+		return true
+	}
+	return false
+}
+
+// LastModTime returns the newest modification time of the given fonts and the
+// templates of the given context, or the zero time if any of the font files
+// cannot be stat'ed.
+func LastModTime(fonts []*font.Font, ctx HandlerContext) time.Time {
+	modtime := ctx.TemplatesModTime
+	for _, fnt := range fonts {
+		fModTime, err := fnt.ModTime()
+		if err != nil {
+			return time.Time{}
+		}
+		if fModTime.After(modtime) {
+			modtime = fModTime
+		}
+	}
+	return modtime
+}
+
+// LastModified sets the Last-Modified HTTP response header to the given
+// modification time, unless it is zero, and validates it against the
+// If-Modified-Since HTTP request header. The header is ignored if the request
+// has an If-None-Match header, whose entity tags take precedence, see RFC
+// 7232, section 6. Returns true if the resource has not been modified, in
+// which case a 304 Not Modified response has been sent.
+func LastModified(w http.ResponseWriter, r *http.Request, modtime time.Time,
+	ctx HandlerContext) bool {
+	if modtime.IsZero() {
+		return false
+	}
+	// HTTP dates have a resolution of one second.
+	modtime = modtime.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", modtime.Format(http.TimeFormat))
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modtime.After(since) {
+		return false
+	}
+	maxAge := strconv.FormatUint(ctx.Flags.CcMaxAge, 10)
+	w.Header().Set("Cache-Control", "max-age="+maxAge)
+	NotModified(w, r)
+	return true
+}
+
+// MatchEtag reports whether the given value of the If-None-Match HTTP request
+// header matches the given entity tag, see RFC 7232, section 3.2. The header
+// is either "*", which matches any entity tag, or a comma-separated list of
+// entity tags, compared using the weak comparison function: the "W/" prefixes
+// are ignored. The parsing stops at the first malformed entity tag.
+func MatchEtag(header, etag string) bool {
+	opaque := strings.TrimPrefix(etag, "W/")
+	for {
+		header = strings.TrimLeft(header, " \t,")
+		if header == "" {
+			return false
+		}
+		if header[0] == '*' {
+			return true
+		}
+		header = strings.TrimPrefix(header, "W/")
+		if len(header) < 2 || header[0] != '"' {
+			return false
+		}
+		end := strings.IndexByte(header[1:], '"')
+		if end < 0 {
+			return false
 		}
-		w.Header().Set("ETag", etag)
-		if r.Header.Get("If-None-Match") == etag {
-			maxAge := strconv.FormatUint(ctx.Flags.CcMaxAge, 10)
-			w.Header().Set("Cache-Control", "max-age="+maxAge)
-			NotModified(w, r)
+		if header[:end+2] == opaque {
 			return true
 		}
-	} else {
-		// Something went wrong and the entity tag cannot be
-		// validated, make sure the entity tag received from
-		// the client is resent.
-		etag := r.Header.Get("If-None-Match")
-		if etag != "" {
-			w.Header().Set("ETag", etag)
+		header = header[end+2:]
+	}
+}
+
+// FontHandler serves the font files linked from the CSS files. The requested
+// font is identified by the last two elements of the URL path, the font family
+// name and a file name made of the weight, style, optional subset name, and
+// font format extension, as generated by FontURL. A subset of the font file is
+// served if the text form value is not empty.
+func FontHandler(w http.ResponseWriter, r *http.Request, ctx HandlerContext) {
+	rLog := RequestLog(r, ctx)
+	if !AllowMethod(w, r, ctx) {
+		return
+	}
+	// Allow only whitelisted referers to fetch the resource.
+	if !ctx.Whitelist.Contains(Referer(r)) {
+		rLog.Info("referer not whitelisted")
+		Forbidden(w, r)
+		return
+	}
+	family := path.Base(path.Dir(r.URL.Path))
+	filename := path.Base(r.URL.Path)
+	ext := path.Ext(filename)
+	format := font.NOF
+	format.FromString(strings.TrimPrefix(ext, "."))
+	if format == font.NOF {
+		rLog.Info("unknown font format", "family", family,
+			"format", strings.TrimPrefix(ext, "."))
+		NotFound(w, r)
+		return
+	}
+	name := strings.TrimSuffix(filename, ext)
+	var subset string
+	if i := strings.Index(name, "."); i >= 0 {
+		name, subset = name[:i], name[i+1:]
+	}
+	query := inventory.Query{
+		RowKey:    family,
+		ColumnKey: format.String() + name,
+	}
+	fnt := ctx.Inventory.Query(query)
+	if fnt != nil && subset != "" {
+		fnt = fnt.SubsetFont(subset)
+	}
+	if fnt == nil {
+		rLog.Info("font not available", "family", family,
+			"query", query.ColumnKey, "subset", subset)
+		NotFound(w, r)
+		return
+	}
+	file, err := os.Open(fnt.Path)
+	if err != nil {
+		rLog.Error("font file not readable", "family", fnt.Family,
+			"path", fnt.Path, "error", err)
+		InternalServerError(w, r)
+		return
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		rLog.Error("font file not readable", "family", fnt.Family,
+			"path", fnt.Path, "error", err)
+		InternalServerError(w, r)
+		return
+	}
+	var content io.ReadSeeker = file
+	text := NormalizeText(r.FormValue("text"))
+	if text != "" || fnt.Generate {
+		data, err := Subset(fnt, text, ctx)
+		if err != nil {
+			rLog.Error("font subset failed", "family", fnt.Family,
+				"path", fnt.Path, "error", err)
+			InternalServerError(w, r)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	if ctx.Flags.Etag {
+		hash := md5.New()
+		io.WriteString(hash, fi.ModTime().String())
+		io.WriteString(hash, strconv.FormatInt(fi.Size(), 10))
+		io.WriteString(hash, fnt.UnicodeRange)
+		io.WriteString(hash, text)
+		// The entity tag is quoted as required by ServeContent
+		// in order to validate it.
+		w.Header().Set("ETag", fmt.Sprintf("\"%x\"", hash.Sum(nil)))
+	}
+	maxAge := strconv.FormatUint(ctx.Flags.CcMaxAge, 10)
+	w.Header().Set("Cache-Control", "max-age="+maxAge)
+	w.Header().Set("Content-Type", fnt.MimeType())
+	// ServeContent takes care of HEAD, range and
+	// conditional requests.
+	http.ServeContent(w, r, filename, fi.ModTime(), content)
+}
+
+// AllowMethod reports whether the request method is allowed by the CSS and
+// font handlers, and should be served by them: GET and HEAD requests are, the
+// latter being answered with the same headers as the former. OPTIONS requests
+// are answered with the allowed methods, along with the CORS preflight headers
+// if CORS is enabled and the origin is allowed, see AllowOrigin, and other
+// methods are not allowed.
+func AllowMethod(w http.ResponseWriter, r *http.Request,
+	ctx HandlerContext) bool {
+	switch r.Method {
+	case "GET", "HEAD":
+		return true
+	case "OPTIONS":
+		w.Header().Set("Allow", AllowedMethods)
+		if ctx.Flags.AcAllowOrigin && AllowOrigin(r.Header.Get("Origin"), ctx) {
+			maxAge := strconv.Itoa(PreflightMaxAge)
+			w.Header().Set("Access-Control-Allow-Methods", AllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", AllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
 		}
+		w.WriteHeader(http.StatusNoContent)
+		return false
 	}
+	RequestLog(r, ctx).Info("method not allowed")
+	MethodNotAllowed(w, r)
 	return false
 }
 
+// FontURL returns the URL of the given font file, relative to the given
+// base URL of the linked font files.
+func FontURL(base string, f *font.Font) string {
+	filename := strconv.Itoa(f.Weight) + f.Style
+	if f.SubsetName != "" {
+		filename += "." + url.PathEscape(f.SubsetName)
+	}
+	filename += "." + f.Format.String()
+	return base + url.PathEscape(f.Family) + "/" + filename
+}
+
+// NormalizeText returns the distinct characters of the given text, sorted
+// in ascending order, so that equivalent texts share the same font subsets.
+// Invalid UTF-8 sequences and control characters are dropped.
+func NormalizeText(text string) string {
+	seen := make(map[rune]bool)
+	var runes []rune
+	for _, r := range text {
+		if r == utf8.RuneError || r < 0x20 || seen[r] {
+			continue
+		}
+		seen[r] = true
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	return string(runes)
+}
+
+// Subset returns the contents of a subset of the given font file containing
+// only the glyphs needed to render the given text or, if the text is empty and
+// the font is a generated subset, the characters of its Unicode range. The
+// contents of the whole font file are returned in the other cases, or if the
+// font file cannot be subset. The subsets are looked up in and added to the
+// font subsets cache of the given context, unless it is nil.
+func Subset(f *font.Font, text string, ctx HandlerContext) ([]byte, error) {
+	c := ctx.Subsets
+	if text == "" && !f.Generate {
+		return f.Contents()
+	}
+	runes := []rune(text)
+	if text == "" {
+		var err error
+		if runes, err = font.ParseUnicodeRange(f.UnicodeRange); err != nil {
+			return nil, err
+		}
+	}
+	modtime, err := f.ModTime()
+	if err != nil {
+		return nil, err
+	}
+	// The modification time is part of the key, so that
+	// subsets of modified font files are not reused.
+	key := f.Path + "\x00" + modtime.String() + "\x00" + text
+	if text == "" {
+		key += f.UnicodeRange
+	}
+	if c != nil {
+		if data, ok := c.Get(key); ok {
+			return data, nil
+		}
+	}
+	data, err := f.Subset(runes)
+	if err == font.ErrSubsetUnsupported {
+		ctx.Log.Debug("font subset not supported, whole font file used",
+			"family", f.Family, "path", f.Path)
+		return f.Contents()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c.Put(key, data)
+	}
+	return data, nil
+}
+
 func MakeHandler(fn HandlerFunc, ctx HandlerContext) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if ctx.Flags.AcAllowOrigin {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
+		rCtx := ctx
+		if ctx.Resources != nil {
+			rCtx = ctx.Resources.context(ctx)
 		}
 		if ctx.Flags.Version != "" {
 			w.Header().Set("Server", ctx.Flags.Version)
 		}
 		// Set the "Vary: Accept-Encoding" HTTP response
-		// header if gzip compression is enabled.
-		if ctx.Flags.Gzip {
+		// header if compression is enabled.
+		if ctx.Flags.Compress {
 			w.Header().Set("Vary", "Accept-Encoding")
 		}
-		fn(w, r, ctx)
+		// Echo the origin of cross-origin requests
+		// if it is allowed, the response varies
+		// with it whenever CORS is enabled.
+		if ctx.Flags.AcAllowOrigin {
+			w.Header().Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if AllowOrigin(origin, rCtx) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+		fn(w, r, rCtx)
+	}
+}
+
+// AllowOrigin reports whether the given origin, the value of the Origin HTTP
+// request header, is allowed to read the responses of cross-origin requests:
+// it must be one of the origins of the given context if any, compared without
+// regard to case, or else be present in its whitelist.
+func AllowOrigin(origin string, ctx HandlerContext) bool {
+	if origin == "" || origin == "null" {
+		return false
+	}
+	if len(ctx.Flags.AcOrigins) > 0 {
+		for _, o := range ctx.Flags.AcOrigins {
+			if strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+	return ctx.Whitelist.Contains(origin + "/")
+}
+
+// RequestLog returns the logger of the given context, adding the method, URL,
+// and referer of the given request to each record.
+func RequestLog(r *http.Request, ctx HandlerContext) *log.Logger {
+	return ctx.Log.With("method", r.Method, "url", r.URL.String(),
+		"referer", Referer(r))
+}
+
+// Referer returns the address of the document which initiated the request.
+// The Origin HTTP request header takes precedence over the Referer HTTP request
+// header, since browsers fetch web fonts using cross-origin requests and the
+// referer of a font file linked from a CSS file is the CSS file itself.
+func Referer(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin + "/"
+	}
+	return r.Referer()
+}
+
+// SplitSubsets splits the given fonts, the sources of one font face, into the
+// sources of one font face for each of their subsets, in order. Only the named
+// subsets are kept, unless names is nil. The fonts lacking any of the subsets,
+// e.g. the font formats which can't be subset, are the sources of one more
+// font face covering all the characters, coming first so that browsers prefer
+// the subsets whose Unicode range includes a character, and using it only as
+// a fallback. The fonts are not split if they have no such subsets.
+func SplitSubsets(fonts []*font.Font, names []string) [][]*font.Font {
+	var wanted map[string]bool
+	if names != nil {
+		wanted = make(map[string]bool)
+		for _, name := range names {
+			wanted[name] = true
+		}
+	}
+	var subsets []string
+	seen := make(map[string]bool)
+	for _, fnt := range fonts {
+		for _, subset := range fnt.Subsets {
+			name := subset.SubsetName
+			if seen[name] || (wanted != nil && !wanted[name]) {
+				continue
+			}
+			seen[name] = true
+			subsets = append(subsets, name)
+		}
+	}
+	if len(subsets) == 0 {
+		return [][]*font.Font{fonts}
+	}
+	var whole []*font.Font
+	for _, fnt := range fonts {
+		for _, name := range subsets {
+			if fnt.SubsetFont(name) == nil {
+				whole = append(whole, fnt)
+				break
+			}
+		}
+	}
+	var faceFonts [][]*font.Font
+	if len(whole) > 0 {
+		faceFonts = append(faceFonts, whole)
+	}
+	for _, name := range subsets {
+		var sFonts []*font.Font
+		for _, fnt := range fonts {
+			if subset := fnt.SubsetFont(name); subset != nil {
+				sFonts = append(sFonts, subset)
+			}
+		}
+		faceFonts = append(faceFonts, sFonts)
 	}
+	return faceFonts
 }
 
 // Queries builds and returns a slice of pointers to inventory queries from the
-// given family form value and font format format.
+// given family form value and font format format. If the font format is NOF,
+// the column keys of the queries don't contain the font format.
 func Queries(family string, format font.Format) []*inventory.Query {
 	var queries []*inventory.Query
 	if strings.HasPrefix(family, "|") || strings.HasPrefix(family, ":") ||
@@ -241,7 +991,10 @@ func Queries(family string, format font.Format) []*inventory.Query {
 			for _, s := range styles {
 				q := new(inventory.Query)
 				q.RowKey = familyStyles[0]
-				if _, err := strconv.Atoi(s); err == nil {
+				// The weight may also be a range
+				// of weights, such as 100..900.
+				style := strings.TrimLeft(s, "0123456789.")
+				if style == "" && s != "" {
 					// Only weight is specified,
 					// default style to normal.
 					q.ColumnKey = s + "normal"
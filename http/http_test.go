@@ -6,18 +6,22 @@ package http
 
 import (
 	"bytes"
-	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"text/template"
+	"time"
 
+	"github.com/noll/mjau/cache"
 	"github.com/noll/mjau/font"
 	"github.com/noll/mjau/inventory"
+	"github.com/noll/mjau/log"
 	"github.com/noll/mjau/test"
 	"github.com/noll/mjau/util"
 	"github.com/noll/mjau/whitelist"
@@ -36,156 +40,193 @@ var QueriesCases = []struct {
 }{
 	// Case 1
 	{
-		Family:      "Amaranth",
-		font.Format: font.EOT,
+		Family: "Amaranth",
+		Format: font.EOT,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Amaranth", "eot400normal"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "eot400normal"},
 		},
 	},
 	// Case 2
 	{
-		Family:      "Amaranth|Open+Sans",
-		font.Format: font.WOFF,
+		Family: "Amaranth|Open+Sans",
+		Format: font.WOFF,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Amaranth", "woff400normal"},
-			&inventory.Query{"Open+Sans", "woff400normal"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "woff400normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff400normal"},
 		},
 	},
 	// Case 3
 	{
-		Family:      "Amaranth:700italic|Open+Sans:800normal",
-		font.Format: font.EOT,
+		Family: "Amaranth:700italic|Open+Sans:800normal",
+		Format: font.EOT,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Amaranth", "eot700italic"},
-			&inventory.Query{"Open+Sans", "eot800normal"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "eot700italic"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "eot800normal"},
 		},
 	},
 	// Case 4
 	{
-		Family:      "Amaranth:400normal|Open+Sans:300normal,600italic",
-		font.Format: font.WOFF,
+		Family: "Amaranth:400normal|Open+Sans:300normal,600italic",
+		Format: font.WOFF,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Amaranth", "woff400normal"},
-			&inventory.Query{"Open+Sans", "woff300normal"},
-			&inventory.Query{"Open+Sans", "woff600italic"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "woff400normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff300normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff600italic"},
 		},
 	},
 	// Case 5
 	{
-		Family:      "Amaranth:400normal,700normal|Open+Sans:700normal",
-		font.Format: font.EOT,
+		Family: "Amaranth:400normal,700normal|Open+Sans:700normal",
+		Format: font.EOT,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Amaranth", "eot400normal"},
-			&inventory.Query{"Amaranth", "eot700normal"},
-			&inventory.Query{"Open+Sans", "eot700normal"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "eot400normal"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "eot700normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "eot700normal"},
 		},
 	},
 	// Case 6
 	{
-		Family:      "Amaranth:400,700|Open+Sans:700",
-		font.Format: font.EOT,
+		Family: "Amaranth:400,700|Open+Sans:700",
+		Format: font.EOT,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Amaranth", "eot400normal"},
-			&inventory.Query{"Amaranth", "eot700normal"},
-			&inventory.Query{"Open+Sans", "eot700normal"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "eot400normal"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "eot700normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "eot700normal"},
 		},
 	},
 	// Case 7
 	{
-		Family:      "|Amaranth",
-		font.Format: font.WOFF,
+		Family: "|Amaranth",
+		Format: font.WOFF,
 	},
 	// Case 8
 	{
-		Family:      ":Amaranth",
-		font.Format: font.WOFF,
+		Family: ":Amaranth",
+		Format: font.WOFF,
 	},
 	// Case 9
 	{
-		Family:      ",Amaranth",
-		font.Format: font.WOFF,
+		Family: ",Amaranth",
+		Format: font.WOFF,
 	},
 	// Case 10
 	{
-		Family:      "|Open+Sans:700,300italic",
-		font.Format: font.WOFF,
+		Family: "|Open+Sans:700,300italic",
+		Format: font.WOFF,
 	},
 	// Case 11
 	{
-		Family:      ":Open+Sans:700,300italic",
-		font.Format: font.WOFF,
+		Family: ":Open+Sans:700,300italic",
+		Format: font.WOFF,
 	},
 	// Case 12
 	{
-		Family:      ",Open+Sans:700,300italic",
-		font.Format: font.WOFF,
+		Family: ",Open+Sans:700,300italic",
+		Format: font.WOFF,
 	},
 	// Case 13
 	{
-		Family:      "Open+Sans:700,300italic|",
-		font.Format: font.WOFF,
+		Family: "Open+Sans:700,300italic|",
+		Format: font.WOFF,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Open+Sans", "woff700normal"},
-			&inventory.Query{"Open+Sans", "woff300italic"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff700normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff300italic"},
 		},
 	},
 	// Case 14
 	{
-		Family:      "Open+Sans:700,300italic||Amaranth",
-		font.Format: font.EOT,
+		Family: "Open+Sans:700,300italic||Amaranth",
+		Format: font.EOT,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Open+Sans", "eot700normal"},
-			&inventory.Query{"Open+Sans", "eot300italic"},
-			&inventory.Query{"Amaranth", "eot400normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "eot700normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "eot300italic"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "eot400normal"},
 		},
 	},
 	// Case 15
 	{
-		Family:      "Open+Sans:700,300italic,",
-		font.Format: font.EOT,
+		Family: "Open+Sans:700,300italic,",
+		Format: font.EOT,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Open+Sans", "eot700normal"},
-			&inventory.Query{"Open+Sans", "eot300italic"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "eot700normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "eot300italic"},
 		},
 	},
 	// Case 16
 	{
-		Family:      "Open+Sans:700,300italic,|Amaranth",
-		font.Format: font.WOFF,
+		Family: "Open+Sans:700,300italic,|Amaranth",
+		Format: font.WOFF,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Open+Sans", "woff700normal"},
-			&inventory.Query{"Open+Sans", "woff300italic"},
-			&inventory.Query{"Amaranth", "woff400normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff700normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff300italic"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "woff400normal"},
 		},
 	},
 	// Case 17
 	{
-		Family:      "Open+Sans:700,300italic,,",
-		font.Format: font.WOFF,
+		Family: "Open+Sans:700,300italic,,",
+		Format: font.WOFF,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Open+Sans", "woff700normal"},
-			&inventory.Query{"Open+Sans", "woff300italic"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff700normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff300italic"},
 		},
 	},
 	// Case 18
 	{
-		Family:      "Open+Sans:700,300italic,,|Amaranth",
-		font.Format: font.EOT,
+		Family: "Open+Sans:700,300italic,,|Amaranth",
+		Format: font.EOT,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Open+Sans", "eot700normal"},
-			&inventory.Query{"Open+Sans", "eot300italic"},
-			&inventory.Query{"Amaranth", "eot400normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "eot700normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "eot300italic"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "eot400normal"},
 		},
 	},
 	// Case 19
 	{
-		Family:      "Open+Sans:700,300italic,,400|Amaranth",
-		font.Format: font.WOFF,
+		Family: "Open+Sans:700,300italic,,400|Amaranth",
+		Format: font.WOFF,
+		Queries: []*inventory.Query{
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff700normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff300italic"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff400normal"},
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "woff400normal"},
+		},
+	},
+	// Case 20
+	{
+		Family: "Amaranth:700|Open+Sans:300italic",
+		Format: font.WOFF2,
+		Queries: []*inventory.Query{
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "woff2700normal"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "woff2300italic"},
+		},
+	},
+	// Case 21
+	{
+		Family: "Amaranth:400italic|Open+Sans",
+		Format: font.TTF,
+		Queries: []*inventory.Query{
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "ttf400italic"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "ttf400normal"},
+		},
+	},
+	// Case 22
+	{
+		Family: "Amaranth:400italic|Open+Sans",
+		Format: font.NOF,
+		Queries: []*inventory.Query{
+			&inventory.Query{RowKey: "Amaranth", ColumnKey: "400italic"},
+			&inventory.Query{RowKey: "Open+Sans", ColumnKey: "400normal"},
+		},
+	},
+	// Case 23
+	{
+		Family: "Foo:100..900,550,300..700italic",
+		Format: font.WOFF2,
 		Queries: []*inventory.Query{
-			&inventory.Query{"Open+Sans", "woff700normal"},
-			&inventory.Query{"Open+Sans", "woff300italic"},
-			&inventory.Query{"Open+Sans", "woff400normal"},
-			&inventory.Query{"Amaranth", "woff400normal"},
+			&inventory.Query{RowKey: "Foo", ColumnKey: "woff2100..900normal"},
+			&inventory.Query{RowKey: "Foo", ColumnKey: "woff2550normal"},
+			&inventory.Query{RowKey: "Foo", ColumnKey: "woff2300..700italic"},
 		},
 	},
 }
@@ -210,47 +251,420 @@ func TestFontFaceFromFont(t *testing.T) {
 	test.Verify(t, 6, 0, wMimeType, ff.MimeType)
 	test.Verify(t, 7, 0, f.Style, ff.Style)
 	test.Verify(t, 8, 0, f.Weight, ff.Weight)
+	test.VerifyFatal(t, 9, 0, 1, len(ff.Sources))
+	test.Verify(t, 10, 0, ff.Base64Data, ff.Sources[0].Base64Data)
+	test.Verify(t, 11, 0, "woff", ff.Sources[0].CssFormat)
+}
+
+func TestFontFaceFromSources(t *testing.T) {
+	ff := &FontFace{}
+	f := font.Font{
+		Family: "Amaranth",
+		Format: font.EOT,
+		Path:   filepath.Join(amf, "amaranth-regular.eot"),
+		Style:  "normal",
+		Weight: 400,
+	}
+	sources := []*Source{
+		// Source 1
+		&Source{
+			CssFormat: "embedded-opentype",
+			Format:    "eot",
+			MimeType:  "application/vnd.ms-fontobject",
+			URL:       "/font/Amaranth/400normal.eot",
+		},
+		// Source 2
+		&Source{
+			CssFormat: "woff",
+			Format:    "woff",
+			MimeType:  "application/x-font-woff",
+			URL:       "/font/Amaranth/400normal.woff",
+		},
+	}
+	ff.FromSources(f, sources)
+	test.Verify(t, 1, 0, "", ff.Base64Data)
+	test.Verify(t, 2, 0, f.Family, ff.Family)
+	test.Verify(t, 3, 0, sources[0].Format, ff.Format)
+	test.Verify(t, 4, 0, sources[0].MimeType, ff.MimeType)
+	test.Verify(t, 5, 0, len(sources), len(ff.Sources))
+	test.Verify(t, 6, 0, f.Style, ff.Style)
+	test.Verify(t, 7, 0, sources[0].URL, ff.URL)
+	test.Verify(t, 8, 0, f.Weight, ff.Weight)
+}
+
+func TestFontFaceFromSourcesVariable(t *testing.T) {
+	var cases = []struct {
+		Font        font.Font
+		Stretch     string
+		Style       string
+		WeightRange string
+	}{
+		// Case 1
+		{
+			Font: font.Font{
+				Axes: []font.Axis{
+					{Tag: "wght", Min: 100, Default: 400, Max: 900},
+					{Tag: "wdth", Min: 62.5, Default: 100, Max: 100},
+				},
+				Style:  "normal",
+				Weight: 400,
+			},
+			Stretch:     "62.5% 100%",
+			Style:       "normal",
+			WeightRange: "100 900",
+		},
+		// Case 2
+		{
+			Font: font.Font{
+				Axes: []font.Axis{
+					{Tag: "slnt", Min: -12, Default: 0, Max: 0},
+				},
+				Style:  "normal",
+				Weight: 400,
+			},
+			Style: "oblique 0deg 12deg",
+		},
+		// Case 3
+		{
+			Font: font.Font{
+				Axes: []font.Axis{
+					{Tag: "slnt", Min: -12, Default: 0, Max: 0},
+				},
+				Style:  "italic",
+				Weight: 400,
+			},
+			Style: "italic",
+		},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		ff := new(FontFace)
+		ff.FromSources(c.Font, []*Source{new(Source)})
+		test.Verify(t, 1, j, c.Stretch, ff.Stretch)
+		test.Verify(t, 2, j, c.Style, ff.Style)
+		test.Verify(t, 3, j, c.Font.Weight, ff.Weight)
+		test.Verify(t, 4, j, c.WeightRange, ff.WeightRange)
+	}
+}
+
+func TestFontFaceFromFontURL(t *testing.T) {
+	ff := &FontFace{}
+	f := font.Font{
+		Family: "Amaranth",
+		Format: font.WOFF,
+		Path:   filepath.Join(amf, "amaranth-regular.woff"),
+		Style:  "normal",
+		Weight: 400,
+	}
+	url := "/font/Amaranth/400normal.woff"
+	err := ff.FromFontURL(f, url)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	test.Verify(t, 2, 0, "", ff.Base64Data)
+	test.Verify(t, 3, 0, f.Family, ff.Family)
+	test.Verify(t, 4, 0, f.Format.String(), ff.Format)
+	test.Verify(t, 5, 0, f.MimeType(), ff.MimeType)
+	test.Verify(t, 6, 0, f.Style, ff.Style)
+	test.Verify(t, 7, 0, url, ff.URL)
+	test.Verify(t, 8, 0, f.Weight, ff.Weight)
+	test.Verify(t, 9, 0, url+"?#iefix", ff.IefixURL)
+
+	// The fragment follows the query of the URL, if any.
+	err = ff.FromFontURL(f, url+"?text=Hi")
+	test.VerifyFatal(t, 10, 0, true, nil == err)
+	test.Verify(t, 11, 0, url+"?text=Hi#iefix", ff.IefixURL)
+}
+
+func TestTemplatesIefixURL(t *testing.T) {
+	all := filepath.Join(tp, "all.css.tmpl")
+	eot := filepath.Join(tp, "eot.css.tmpl")
+	tmpl, err := template.ParseFiles(all, eot)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+
+	// A subset of Amaranth Regular linked in EOT format.
+	f := font.Font{
+		Family: "Amaranth",
+		Format: font.EOT,
+		Path:   filepath.Join(amf, "amaranth-regular.eot"),
+		Style:  "normal",
+		Weight: 400,
+	}
+	ff := new(FontFace)
+	err = ff.FromFontURL(f, "/font/Amaranth/400normal.eot?text=Hi")
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+
+	wIefix := "url(/font/Amaranth/400normal.eot?text=Hi#iefix)"
+	for i, name := range []string{"all.css.tmpl", "eot.css.tmpl"} {
+		j := i + 1
+
+		buf := new(bytes.Buffer)
+		err := tmpl.ExecuteTemplate(buf, name, []*FontFace{ff})
+		test.VerifyFatal(t, 3, j, true, nil == err)
+		test.Verify(t, 4, j, true, strings.Contains(buf.String(), wIefix))
+		test.Verify(t, 5, j, false, strings.Contains(buf.String(), "?#iefix"))
+	}
+}
+
+func TestFontHandler(t *testing.T) {
+	// Build an inventory.
+	inv := inventory.New()
+	err := inv.Build(fl)
+	test.VerifyFatal(t, 1, 0, true, err == nil)
+
+	// Build a whitelist allowing only one referer.
+	wl := whitelist.New()
+	wl.Domains = append(wl.Domains, "http://one/")
+
+	arPath := filepath.Join(amf, "amaranth-regular.woff")
+	arData, err := ioutil.ReadFile(arPath)
+	test.VerifyFatal(t, 2, 0, true, err == nil)
+	osrPath := filepath.Join(fl, "Open Sans", "os-regular.eot")
+	osrData, err := ioutil.ReadFile(osrPath)
+	test.VerifyFatal(t, 3, 0, true, err == nil)
+	ar := &font.Font{Format: font.WOFF, Path: arPath}
+	arSubset, err := ar.Subset([]rune("Hi"))
+	test.VerifyFatal(t, 4, 0, true, err == nil)
+
+	var cases = []struct {
+		Body       []byte
+		Header     map[string]string
+		Method     string
+		Origin     string // Origin client request header.
+		Range      string // Range client request header.
+		StatusCode int
+		URL        string
+	}{
+		// Case 1
+		{
+			Method:     "POST",
+			Origin:     "http://one",
+			StatusCode: http.StatusMethodNotAllowed,
+			URL:        "/font/Amaranth/400normal.woff",
+		},
+		// Case 2
+		{
+			Method:     "GET",
+			StatusCode: http.StatusForbidden,
+			URL:        "/font/Amaranth/400normal.woff",
+		},
+		// Case 3
+		{
+			Header: map[string]string{
+				"Access-Control-Allow-Origin": "",
+				"Vary":                        "Origin",
+			},
+			Method:     "GET",
+			Origin:     "http://two",
+			StatusCode: http.StatusForbidden,
+			URL:        "/font/Amaranth/400normal.woff",
+		},
+		// Case 4
+		{
+			Method:     "GET",
+			Origin:     "http://one",
+			StatusCode: http.StatusNotFound,
+			URL:        "/font/Amaranth/400normal.svg",
+		},
+		// Case 5
+		{
+			Method:     "GET",
+			Origin:     "http://one",
+			StatusCode: http.StatusNotFound,
+			URL:        "/font/Nonexistent/400normal.woff",
+		},
+		// Case 6
+		{
+			Body: arData,
+			Header: map[string]string{
+				"Access-Control-Allow-Origin": "http://one",
+				"Cache-Control":               "max-age=2592000",
+				"Content-Type":                "application/x-font-woff",
+				"Vary":                        "Origin",
+			},
+			Method:     "GET",
+			Origin:     "http://one",
+			StatusCode: http.StatusOK,
+			URL:        "/font/Amaranth/400normal.woff",
+		},
+		// Case 7
+		{
+			Body: arData[4:8],
+			Header: map[string]string{
+				"Cache-Control": "max-age=2592000",
+				"Content-Type":  "application/x-font-woff",
+			},
+			Method:     "GET",
+			Origin:     "http://one",
+			Range:      "bytes=4-7",
+			StatusCode: http.StatusPartialContent,
+			URL:        "/font/Amaranth/400normal.woff",
+		},
+		// Case 8
+		{
+			Body: osrData,
+			Header: map[string]string{
+				"Cache-Control": "max-age=2592000",
+				"Content-Type":  "application/vnd.ms-fontobject",
+			},
+			Method:     "GET",
+			Origin:     "http://one",
+			StatusCode: http.StatusOK,
+			URL:        "/font/Open%20Sans/400normal.eot",
+		},
+		// Case 9
+		{
+			Body: arSubset,
+			Header: map[string]string{
+				"Cache-Control": "max-age=2592000",
+				"Content-Type":  "application/x-font-woff",
+			},
+			Method:     "GET",
+			Origin:     "http://one",
+			StatusCode: http.StatusOK,
+			URL:        "/font/Amaranth/400normal.woff?text=iHi",
+		},
+	}
+
+	ctx := HandlerContext{
+		Flags: Flags{
+			AcAllowOrigin: true,
+			CcMaxAge:      2592000,
+		},
+		Inventory: *inv,
+		Subsets:   cache.New(1 << 20),
+		Whitelist: *wl,
+	}
+	handler := MakeHandler(FontHandler, ctx)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for i, c := range cases {
+		j := i + 1
+
+		client := http.Client{}
+		req, err := http.NewRequest(c.Method, server.URL+c.URL, nil)
+		test.VerifyFatal(t, 4, j, true, nil == err)
+
+		if c.Origin != "" {
+			req.Header.Add("Origin", c.Origin)
+		}
+		if c.Range != "" {
+			req.Header.Add("Range", c.Range)
+		}
+
+		resp, err := client.Do(req)
+		test.VerifyFatal(t, 5, j, true, nil == err)
+		defer resp.Body.Close()
+		test.Verify(t, 6, j, c.StatusCode, resp.StatusCode)
+
+		for k, v := range c.Header {
+			test.Verify(t, 7, j, v, resp.Header.Get(k))
+		}
+
+		if c.Body != nil {
+			gbody, err := ioutil.ReadAll(resp.Body)
+			test.VerifyFatal(t, 8, j, true, nil == err)
+			test.Verify(t, 9, j, true, bytes.Equal(c.Body, gbody))
+		}
+	}
+}
+
+func TestFontURL(t *testing.T) {
+	var cases = []struct {
+		Base string
+		Font *font.Font
+		URL  string
+	}{
+		// Case 1
+		{
+			Base: "/font/",
+			Font: &font.Font{
+				Family: "Amaranth",
+				Format: font.WOFF,
+				Style:  "normal",
+				Weight: 400,
+			},
+			URL: "/font/Amaranth/400normal.woff",
+		},
+		// Case 2
+		{
+			Base: "http://localhost/font/",
+			Font: &font.Font{
+				Family: "Open Sans",
+				Format: font.EOT,
+				Style:  "italic",
+				Weight: 700,
+			},
+			URL: "http://localhost/font/Open%20Sans/700italic.eot",
+		},
+		// Case 3
+		{
+			Base: "/font/",
+			Font: &font.Font{
+				Family:     "Amaranth",
+				Format:     font.WOFF,
+				Style:      "normal",
+				SubsetName: "latin-ext",
+				Weight:     400,
+			},
+			URL: "/font/Amaranth/400normal.latin-ext.woff",
+		},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		test.Verify(t, 1, j, c.URL, FontURL(c.Base, c.Font))
+	}
 }
 
 func TestMakeHandler(t *testing.T) {
+	wl := whitelist.New()
+	wl.Domains = append(wl.Domains, "http://one/")
+
 	var cases = []struct {
 		Context HandlerContext
 		Header  map[string]string
+		Origin  string // Origin client request header.
 	}{
 		// Case 1
 		{
 			Context: HandlerContext{
 				Flags: Flags{
 					AcAllowOrigin: false,
-					Gzip:          true,
+					Compress:      true,
 					Version:       "test/0.1",
 				},
+				Whitelist: *wl,
 			},
 			Header: map[string]string{
 				"Server": "test/0.1",
 				"Vary":   "Accept-Encoding",
 			},
+			Origin: "http://one",
 		},
 		// Case 2
 		{
 			Context: HandlerContext{
 				Flags: Flags{
 					AcAllowOrigin: true,
-					Gzip:          false,
+					Compress:      false,
 					Version:       "test/0.1",
 				},
+				Whitelist: *wl,
 			},
 			Header: map[string]string{
-				"Access-Control-Allow-Origin": "*",
+				"Access-Control-Allow-Origin": "http://one",
 				"Server":                      "test/0.1",
+				"Vary":                        "Origin",
 			},
+			Origin: "http://one",
 		},
 		// Case 3
 		{
 			Context: HandlerContext{
 				Flags: Flags{
 					AcAllowOrigin: false,
-					Gzip:          true,
+					Compress:      true,
 					Version:       "",
 				},
 			},
@@ -265,6 +679,60 @@ func TestMakeHandler(t *testing.T) {
 			},
 			Header: map[string]string{},
 		},
+		// Case 5
+		{
+			Context: HandlerContext{
+				Flags: Flags{
+					AcAllowOrigin: true,
+				},
+				Whitelist: *wl,
+			},
+			Header: map[string]string{
+				"Vary": "Origin",
+			},
+			Origin: "http://two",
+		},
+		// Case 6
+		{
+			Context: HandlerContext{
+				Flags: Flags{
+					AcAllowOrigin: true,
+					AcOrigins:     []string{"http://two"},
+				},
+				Whitelist: *wl,
+			},
+			Header: map[string]string{
+				"Access-Control-Allow-Origin": "http://TWO",
+				"Vary":                        "Origin",
+			},
+			Origin: "http://TWO",
+		},
+		// Case 7
+		{
+			Context: HandlerContext{
+				Flags: Flags{
+					AcAllowOrigin: true,
+					AcOrigins:     []string{"http://two"},
+				},
+				Whitelist: *wl,
+			},
+			Header: map[string]string{
+				"Vary": "Origin",
+			},
+			Origin: "http://one",
+		},
+		// Case 8
+		{
+			Context: HandlerContext{
+				Flags: Flags{
+					AcAllowOrigin: true,
+				},
+				Whitelist: *wl,
+			},
+			Header: map[string]string{
+				"Vary": "Origin",
+			},
+		},
 	}
 
 	for i, c := range cases {
@@ -275,17 +743,22 @@ func TestMakeHandler(t *testing.T) {
 		defer server.Close()
 
 		client := http.Client{}
-		resp, err := client.Get(server.URL)
-		defer resp.Body.Close()
+		req, err := http.NewRequest("GET", server.URL, nil)
 		test.VerifyFatal(t, 1, j, true, nil == err)
+		if c.Origin != "" {
+			req.Header.Set("Origin", c.Origin)
+		}
+		resp, err := client.Do(req)
+		test.VerifyFatal(t, 2, j, true, nil == err)
+		defer resp.Body.Close()
 
 		wHeader := c.Header
 		gHeader := resp.Header
-		test.Verify(t, 2, j, wHeader["Server"], gHeader.Get("Server"))
-		test.Verify(t, 3, j, wHeader["Vary"], gHeader.Get("Vary"))
+		test.Verify(t, 3, j, wHeader["Server"], gHeader.Get("Server"))
+		test.Verify(t, 4, j, wHeader["Vary"], gHeader.Get("Vary"))
 		wAcAllowOrigin := wHeader["Access-Control-Allow-Origin"]
 		gAcAllowOrigin := gHeader.Get("Access-Control-Allow-Origin")
-		test.Verify(t, 4, j, wAcAllowOrigin, gAcAllowOrigin)
+		test.Verify(t, 5, j, wAcAllowOrigin, gAcAllowOrigin)
 	}
 }
 
@@ -309,10 +782,11 @@ func TestCssHandler(t *testing.T) {
 	aawl.Domains = append(aawl.Domains, "")
 
 	// Parse templates.
-	// Used in cases 7-9.
+	// Used in cases 7-11.
+	all := filepath.Join(tp, "all.css.tmpl")
 	eot := filepath.Join(tp, "eot.css.tmpl")
 	woff := filepath.Join(tp, "woff.css.tmpl")
-	tmpl, err := template.ParseFiles(eot, woff)
+	tmpl, err := template.ParseFiles(all, eot, woff)
 	test.VerifyFatal(t, 2, 0, true, err == nil)
 
 	// Execute template containing Amaranth Regular.
@@ -344,42 +818,114 @@ func TestCssHandler(t *testing.T) {
 	test.VerifyFatal(t, 5, 0, true, nil == err)
 	arBody := buf.Bytes()
 
-	// Generate the entity tag corresponding to Amaranth Regular.
-	// Uncompressed response.
-	// Used in case 7.
-	hash := md5.New()
-	modtime, err := ar.ModTime()
+	// Execute template containing Amaranth Regular
+	// linked instead of embedded.
+	// Used in case 10.
+	arffLink := new(FontFace)
+	err = arffLink.FromFontURL(*ar, FontURL("/font/", ar))
 	test.VerifyFatal(t, 6, 0, true, nil == err)
-	io.WriteString(hash, modtime.String())
-	arEtag := fmt.Sprintf("%x", hash.Sum(nil))
+	buf = new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(buf, tmplName, []*FontFace{arffLink})
+	test.VerifyFatal(t, 7, 0, true, nil == err)
+	arBodyLink := buf.Bytes()
+
+	// Generate the entity tags corresponding to Amaranth Regular.
+	etag := func(body []byte, suffix string) string {
+		sum := sha256.Sum256(body)
+		return fmt.Sprintf("\"%x%s\"", sum[:16], suffix)
+	}
+	// Uncompressed response.
+	// Used in cases 7 and 18-20.
+	arEtag := etag(arBody, "")
 	// Gzip compressed response.
 	// Used in cases 8-9.
-	arEtagGzip := arEtag + "+gzip"
+	arEtagGzip := etag(arBody, "+gzip")
+	// Linked font files response.
+	// Used in case 10.
+	arEtagLink := etag(arBodyLink, "")
+
+	// Last modification time of Amaranth Regular.
+	// Used in cases 21-23.
+	arModTime, err := ar.ModTime()
+	test.VerifyFatal(t, 8, 0, true, nil == err)
+	arLastModified := arModTime.UTC().Format(http.TimeFormat)
+	arModifiedBefore := arModTime.Add(-time.Hour).UTC().Format(http.TimeFormat)
+
+	// Execute template containing Amaranth Regular
+	// linked in EOT format.
+	// Used in case 12.
+	are := &font.Font{
+		Family: "Amaranth",
+		Format: font.EOT,
+		Path:   filepath.Join(amf, "amaranth-regular.eot"),
+		Style:  "normal",
+		Weight: 400,
+	}
+	areff := new(FontFace)
+	err = areff.FromFontURL(*are, FontURL("/font/", are))
+	test.VerifyFatal(t, 9, 0, true, nil == err)
+	buf = new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(buf, "eot.css.tmpl", []*FontFace{areff})
+	test.VerifyFatal(t, 10, 0, true, nil == err)
+	arBodyEot := buf.Bytes()
+
+	// Execute template containing a subset of Amaranth
+	// Regular, embedded and linked.
+	// Used in cases 14-15.
+	arSubset, err := ar.Subset([]rune("Hi"))
+	test.VerifyFatal(t, 11, 0, true, nil == err)
+	arsSource := new(Source)
+	err = arsSource.FromData(*ar, arSubset)
+	test.VerifyFatal(t, 12, 0, true, nil == err)
+	arsff := new(FontFace)
+	arsff.FromSources(*ar, []*Source{arsSource})
+	buf = new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(buf, tmplName, []*FontFace{arsff})
+	test.VerifyFatal(t, 13, 0, true, nil == err)
+	arBodySubset := buf.Bytes()
+	arsffLink := new(FontFace)
+	err = arsffLink.FromFontURL(*ar, FontURL("/font/", ar)+"?text=Hi")
+	test.VerifyFatal(t, 14, 0, true, nil == err)
+	buf = new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(buf, tmplName, []*FontFace{arsffLink})
+	test.VerifyFatal(t, 15, 0, true, nil == err)
+	arBodySubsetLink := buf.Bytes()
+
+	// Expected response containing Amaranth Regular
+	// linked in all the available formats.
+	// Used in case 11.
+	arBodyAll := []byte(`@charset "utf-8";
+
+@font-face {
+	font-family: "Amaranth";
+	src: url(/font/Amaranth/400normal.eot);
+	src: url(/font/Amaranth/400normal.eot?#iefix) format("embedded-opentype"),
+		url(/font/Amaranth/400normal.woff) format("woff");
+	font-style: normal;
+	font-weight: 400;
+}
+`)
 
 	var cases = []struct {
-		Body        []byte
-		Context     HandlerContext
-		Header      map[string]string
-		IfNoneMatch string // If-None-Match client request header.
-		Request     *Request
-		StatusCode  int
+		Body            []byte
+		Context         HandlerContext
+		Header          map[string]string
+		IfModifiedSince string // If-Modified-Since client request header.
+		IfNoneMatch     string // If-None-Match client request header.
+		Request         *Request
+		StatusCode      int
+		UserAgent       string // User-Agent client request header.
 	}{
 		// Case 1
 		{
-			Header: map[string]string{
-				"Content-Type": "text/plain; charset=utf-8",
-			},
 			Request: &Request{
 				Method: "POST",
 				URL:    "",
 			},
-			StatusCode: http.StatusNotImplemented,
+			StatusCode: http.StatusMethodNotAllowed,
 		},
 		// Case 2
 		{
-			Header: map[string]string{
-				"Content-Type": "text/plain; charset=utf-8",
-			},
 			Request: &Request{
 				Method: "GET",
 				URL:    "",
@@ -391,9 +937,6 @@ func TestCssHandler(t *testing.T) {
 			Context: HandlerContext{
 				Whitelist: *aawl,
 			},
-			Header: map[string]string{
-				"Content-Type": "text/plain; charset=utf-8",
-			},
 			Request: &Request{
 				Method: "GET",
 				URL:    "?family=",
@@ -405,9 +948,6 @@ func TestCssHandler(t *testing.T) {
 			Context: HandlerContext{
 				Whitelist: *aawl,
 			},
-			Header: map[string]string{
-				"Content-Type": "text/plain; charset=utf-8",
-			},
 			Request: &Request{
 				Method: "GET",
 				URL:    "?family=Amaranth&format=nonexistent",
@@ -419,9 +959,6 @@ func TestCssHandler(t *testing.T) {
 			Context: HandlerContext{
 				Whitelist: *aawl,
 			},
-			Header: map[string]string{
-				"Content-Type": "text/plain; charset=utf-8",
-			},
 			Request: &Request{
 				Method: "GET",
 				URL:    "?family=|Amaranth",
@@ -438,8 +975,7 @@ func TestCssHandler(t *testing.T) {
 				Whitelist: *aawl,
 			},
 			Header: map[string]string{
-				"Content-Type": "text/plain; charset=utf-8",
-				"Etag":         "",
+				"Etag": "",
 			},
 			Request: &Request{
 				Method: "GET",
@@ -475,8 +1011,8 @@ func TestCssHandler(t *testing.T) {
 			Body: arBody,
 			Context: HandlerContext{
 				Flags: Flags{
-					Etag: true,
-					Gzip: true,
+					Compress: true,
+					Etag:     true,
 				},
 				Inventory: *inv,
 				Templates: *tmpl,
@@ -497,8 +1033,8 @@ func TestCssHandler(t *testing.T) {
 		{
 			Context: HandlerContext{
 				Flags: Flags{
-					Etag: true,
-					Gzip: true,
+					Compress: true,
+					Etag:     true,
 				},
 				Inventory: *inv,
 				Templates: *tmpl,
@@ -515,6 +1051,333 @@ func TestCssHandler(t *testing.T) {
 			},
 			StatusCode: http.StatusNotModified,
 		},
+		// Case 10
+		{
+			Body: arBodyLink,
+			Context: HandlerContext{
+				Flags: Flags{
+					Etag:    true,
+					FontURL: "/font/",
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Content-Type":  "text/css; charset=utf-8",
+				"Etag":          arEtagLink,
+			},
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth&delivery=link",
+			},
+			StatusCode: http.StatusOK,
+		},
+		// Case 11
+		{
+			Body: arBodyAll,
+			Context: HandlerContext{
+				Flags: Flags{
+					FontURL: "/font/",
+					Link:    true,
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Content-Type":  "text/css; charset=utf-8",
+			},
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth&format=all",
+			},
+			StatusCode: http.StatusOK,
+		},
+		// Case 12
+		{
+			Body: arBodyEot,
+			Context: HandlerContext{
+				Flags: Flags{
+					FontURL: "/font/",
+					Link:    true,
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Content-Type":  "text/css; charset=utf-8",
+				"Vary":          "User-Agent",
+			},
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth",
+			},
+			StatusCode: http.StatusOK,
+			UserAgent:  "Mozilla/4.0 (compatible; MSIE 8.0; Windows NT 6.1)",
+		},
+		// Case 13
+		{
+			Body: arBodyLink,
+			Context: HandlerContext{
+				Flags: Flags{
+					FontURL: "/font/",
+					Link:    true,
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Content-Type":  "text/css; charset=utf-8",
+				"Vary":          "User-Agent",
+			},
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth",
+			},
+			StatusCode: http.StatusOK,
+			UserAgent: "Mozilla/5.0 (X11; Linux x86_64) " +
+				"AppleWebKit/537.36 (KHTML, like Gecko) " +
+				"Chrome/120.0.0.0 Safari/537.36",
+		},
+		// Case 14
+		{
+			Body: arBodySubset,
+			Context: HandlerContext{
+				Inventory: *inv,
+				Subsets:   cache.New(1 << 20),
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Content-Type":  "text/css; charset=utf-8",
+			},
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth&format=woff&text=iH",
+			},
+			StatusCode: http.StatusOK,
+		},
+		// Case 15
+		{
+			Body: arBodySubsetLink,
+			Context: HandlerContext{
+				Flags: Flags{
+					FontURL: "/font/",
+					Link:    true,
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Content-Type":  "text/css; charset=utf-8",
+			},
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth&format=woff&text=HiH",
+			},
+			StatusCode: http.StatusOK,
+		},
+		// Case 16
+		{
+			Body: append(append([]byte{}, arBodyLink...),
+				"/* Amaranth:300normal is not available, "+
+					"substituted by 400normal */\n"+
+					"/* Nonexistent:400normal is not available */\n"...),
+			Context: HandlerContext{
+				Flags: Flags{
+					FontURL: "/font/",
+					Link:    true,
+					Nearest: true,
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Content-Type":  "text/css; charset=utf-8",
+			},
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth:300|Nonexistent&format=woff",
+			},
+			StatusCode: http.StatusOK,
+		},
+		// Case 17
+		{
+			Context: HandlerContext{
+				Flags: Flags{
+					Nearest: true,
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Nonexistent&format=woff",
+			},
+			StatusCode: http.StatusBadRequest,
+		},
+		// Case 18
+		{
+			Context: HandlerContext{
+				Flags: Flags{
+					Etag: true,
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Etag":          arEtag,
+			},
+			IfNoneMatch: `"other", W/` + arEtag,
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth",
+			},
+			StatusCode: http.StatusNotModified,
+		},
+		// Case 19
+		{
+			Context: HandlerContext{
+				Flags: Flags{
+					Etag: true,
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Etag":          arEtag,
+			},
+			IfNoneMatch: "*",
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth",
+			},
+			StatusCode: http.StatusNotModified,
+		},
+		// Case 20
+		{
+			Body: arBody,
+			Context: HandlerContext{
+				Flags: Flags{
+					Etag: true,
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Content-Type":  "text/css; charset=utf-8",
+				"Etag":          arEtag,
+			},
+			IfNoneMatch: `"other", ` + arEtagLink,
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth",
+			},
+			StatusCode: http.StatusOK,
+		},
+		// Case 21
+		{
+			Context: HandlerContext{
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+			},
+			IfModifiedSince: arLastModified,
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth",
+			},
+			StatusCode: http.StatusNotModified,
+		},
+		// Case 22
+		{
+			Body: arBody,
+			Context: HandlerContext{
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Content-Type":  "text/css; charset=utf-8",
+				"Last-Modified": arLastModified,
+			},
+			IfModifiedSince: arModifiedBefore,
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth",
+			},
+			StatusCode: http.StatusOK,
+		},
+		// Case 23
+		{
+			Body: arBody,
+			Context: HandlerContext{
+				Flags: Flags{
+					Etag: true,
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Content-Type":  "text/css; charset=utf-8",
+				"Etag":          arEtag,
+				"Last-Modified": arLastModified,
+			},
+			IfModifiedSince: arLastModified,
+			IfNoneMatch:     `"other"`,
+			Request: &Request{
+				Method: "GET",
+				URL:    "?family=Amaranth",
+			},
+			StatusCode: http.StatusOK,
+		},
+		// Case 24
+		{
+			Context: HandlerContext{
+				Flags: Flags{
+					Etag: true,
+				},
+				Inventory: *inv,
+				Templates: *tmpl,
+				Whitelist: *aawl,
+			},
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Content-Type":  "text/css; charset=utf-8",
+				"Etag":          arEtag,
+				"Last-Modified": arLastModified,
+			},
+			Request: &Request{
+				Method: "HEAD",
+				URL:    "?family=Amaranth",
+			},
+			StatusCode: http.StatusOK,
+		},
 	}
 
 	for i, c := range cases {
@@ -529,13 +1392,19 @@ func TestCssHandler(t *testing.T) {
 		req, err := http.NewRequest(c.Request.Method, reqURL, nil)
 		test.VerifyFatal(t, 6, j, true, nil == err)
 
+		if c.IfModifiedSince != "" {
+			req.Header.Add("If-Modified-Since", c.IfModifiedSince)
+		}
 		if c.IfNoneMatch != "" {
 			req.Header.Add("If-None-Match", c.IfNoneMatch)
 		}
+		if c.UserAgent != "" {
+			req.Header.Set("User-Agent", c.UserAgent)
+		}
 
 		resp, err := client.Do(req)
-		defer resp.Body.Close()
 		test.VerifyFatal(t, 7, j, true, nil == err)
+		defer resp.Body.Close()
 		test.Verify(t, 8, j, c.StatusCode, resp.StatusCode)
 
 		wContentType := c.Header["Content-Type"]
@@ -550,6 +1419,16 @@ func TestCssHandler(t *testing.T) {
 		gEtag := resp.Header.Get("Etag")
 		test.Verify(t, 11, j, wEtag, gEtag)
 
+		if wVary, ok := c.Header["Vary"]; ok {
+			gVary := resp.Header.Get("Vary")
+			test.Verify(t, 12, j, wVary, gVary)
+		}
+
+		if wLastModified, ok := c.Header["Last-Modified"]; ok {
+			gLastModified := resp.Header.Get("Last-Modified")
+			test.Verify(t, 15, j, wLastModified, gLastModified)
+		}
+
 		if (c.StatusCode == http.StatusOK) &&
 			(resp.StatusCode == http.StatusOK) {
 			gbody, err := ioutil.ReadAll(resp.Body)
@@ -560,6 +1439,553 @@ func TestCssHandler(t *testing.T) {
 	}
 }
 
+func TestCssHandlerLog(t *testing.T) {
+	inv := inventory.New()
+	err := inv.Build(fl)
+	test.VerifyFatal(t, 1, 0, true, err == nil)
+	aawl := whitelist.New()
+	aawl.Domains = append(aawl.Domains, "")
+
+	var cases = []struct {
+		Method    string
+		URL       string
+		Whitelist whitelist.Whitelist
+		Level     string
+		Fields    []string
+	}{
+		// Case 1
+		{"POST", "/css/?family=Amaranth", *aawl, "INFO",
+			[]string{`"msg":"method not allowed"`, `"method":"POST"`}},
+		// Case 2
+		{"GET", "/css/?family=Amaranth", *whitelist.New(), "INFO",
+			[]string{`"msg":"referer not whitelisted"`}},
+		// Case 3
+		{"GET", "/css/?family=Amaranth&format=abc", *aawl, "INFO",
+			[]string{`"msg":"unknown font format"`, `"family":"Amaranth"`,
+				`"format":"abc"`}},
+		// Case 4
+		{"GET", "/css/?family=Foo&format=woff", *aawl, "INFO",
+			[]string{`"msg":"font not available"`, `"family":"Foo"`,
+				`"query":"woff400normal"`}},
+		// Case 5
+		{"GET", "/css/?family=Amaranth&format=woff", *aawl, "", nil},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		buf := new(bytes.Buffer)
+		logger, err := log.New(buf, "info", "json")
+		test.VerifyFatal(t, 1, j, true, err == nil)
+		ctx := HandlerContext{
+			Inventory: *inv,
+			Log:       logger,
+			Templates: *template.Must(template.ParseFiles(
+				filepath.Join(tp, "woff.css.tmpl"))),
+			Whitelist: c.Whitelist,
+		}
+		req, err := http.NewRequest(c.Method, c.URL, nil)
+		test.VerifyFatal(t, 2, j, true, err == nil)
+		req.Header.Set("Referer", "http://example.com/")
+		CssHandler(httptest.NewRecorder(), req, ctx)
+		record := buf.String()
+		if c.Level == "" {
+			test.Verify(t, 3, j, "", record)
+			continue
+		}
+		test.Verify(t, 4, j, true,
+			strings.Contains(record, `"level":"`+c.Level+`"`))
+		test.Verify(t, 5, j, true,
+			strings.Contains(record, `"referer":"http://example.com/"`))
+		for k, field := range c.Fields {
+			test.Verify(t, 6+k, j, true, strings.Contains(record, field))
+		}
+	}
+}
+
+func TestCssHandlerCache(t *testing.T) {
+	inv := inventory.New()
+	err := inv.Build(fl)
+	test.VerifyFatal(t, 1, 0, true, err == nil)
+	aawl := whitelist.New()
+	aawl.Domains = append(aawl.Domains, "")
+	tmpl, err := template.ParseFiles(filepath.Join(tp, "woff.css.tmpl"))
+	test.VerifyFatal(t, 2, 0, true, err == nil)
+	ctx := HandlerContext{
+		Flags:     Flags{Nearest: true},
+		Inventory: *inv,
+		Responses: cache.New(1 << 20),
+		Templates: *tmpl,
+		Whitelist: *aawl,
+	}
+
+	var cases = []struct {
+		URL    string
+		Hits   uint64
+		Misses uint64
+		Len    int
+	}{
+		// Case 1
+		{"/css/?family=Amaranth&format=woff", 0, 1, 1},
+		// Case 2
+		{"/css/?family=Amaranth&format=woff", 1, 1, 1},
+		// Case 3
+		{"/css/?family=Amaranth:400&format=woff", 2, 1, 1},
+		// Case 4
+		{"/css/?family=Amaranth&format=woff&delivery=link", 2, 2, 2},
+		// Case 5
+		{"/css/?family=Amaranth:500&format=woff", 3, 2, 2},
+	}
+
+	var bodies []string
+	for i, c := range cases {
+		j := i + 1
+		req, err := http.NewRequest("GET", c.URL, nil)
+		test.VerifyFatal(t, 1, j, true, err == nil)
+		w := httptest.NewRecorder()
+		CssHandler(w, req, ctx)
+		test.Verify(t, 2, j, http.StatusOK, w.Code)
+		bodies = append(bodies, w.Body.String())
+		stats := ctx.Responses.Stats()
+		test.Verify(t, 3, j, c.Hits, stats.Hits)
+		test.Verify(t, 4, j, c.Misses, stats.Misses)
+		test.Verify(t, 5, j, c.Len, stats.Len)
+	}
+	// Cached CSS files are identical to the rendered ones,
+	// and don't carry the comments of substitutions.
+	test.Verify(t, 6, 0, bodies[0], bodies[1])
+	test.Verify(t, 7, 0, bodies[0], bodies[2])
+	test.Verify(t, 8, 0, false, bodies[0] == bodies[3])
+	test.Verify(t, 9, 0, true, strings.HasPrefix(bodies[4], bodies[0]))
+	test.Verify(t, 10, 0, true, strings.Contains(bodies[4], "substituted"))
+}
+
+func TestCssHandlerCacheReload(t *testing.T) {
+	inv := inventory.New()
+	err := inv.Build(fl)
+	test.VerifyFatal(t, 1, 0, true, err == nil)
+	aawl := whitelist.New()
+	aawl.Domains = append(aawl.Domains, "")
+	// The old templates block while rendering, until
+	// the new ones have been loaded.
+	rendering, reloaded := make(chan bool), make(chan bool)
+	wait := func() string {
+		rendering <- true
+		<-reloaded
+		return ""
+	}
+	oldTmpl, err := template.New("woff.css.tmpl").
+		Funcs(template.FuncMap{"wait": wait}).
+		Parse("{{wait}}old{{range .}} {{.Family}}{{end}}\n")
+	test.VerifyFatal(t, 2, 0, true, err == nil)
+	newTmpl, err := template.New("woff.css.tmpl").
+		Parse("new{{range .}} {{.Family}}{{end}}\n")
+	test.VerifyFatal(t, 3, 0, true, err == nil)
+	responses := cache.New(1 << 20)
+	resources := NewResources(inv, oldTmpl, time.Time{}, aawl)
+	handler := MakeHandler(CssHandler, HandlerContext{
+		Resources: resources,
+		Responses: responses,
+	})
+
+	serve := func() string {
+		req, err := http.NewRequest("GET", "/css/?family=Amaranth&format=woff",
+			nil)
+		test.VerifyFatal(t, 4, 0, true, err == nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Body.String()
+	}
+	done := make(chan string)
+	go func() {
+		done <- serve()
+	}()
+	// Reload the templates while the old ones are
+	// rendering, as the reloader does.
+	<-rendering
+	resources.SetTemplates(newTmpl, time.Time{})
+	responses.Clear()
+	close(reloaded)
+	test.Verify(t, 5, 0, "old Amaranth\n", <-done)
+	test.Verify(t, 6, 0, 1, responses.Len())
+
+	// The CSS file rendered using the old templates
+	// is not served once the new ones are loaded.
+	test.Verify(t, 7, 0, "new Amaranth\n", serve())
+	test.Verify(t, 8, 0, "new Amaranth\n", serve())
+}
+
+// benchmarkCssHandler measures the CSS handler serving two font faces of each
+// font family, embedded in the CSS files, with precomputed CSS fragments or
+// without them.
+func benchmarkCssHandler(b *testing.B, fragments bool) {
+	inv := inventory.New()
+	if err := inv.Build(fl); err != nil {
+		b.Fatal(err)
+	}
+	aawl := whitelist.New()
+	aawl.Domains = append(aawl.Domains, "")
+	tmpl, err := template.ParseFiles(filepath.Join(tp, "woff.css.tmpl"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := HandlerContext{
+		Inventory:       *inv,
+		Templates:       *tmpl,
+		TemplatesDigest: TemplatesDigest(tmpl),
+		Whitelist:       *aawl,
+	}
+	if fragments {
+		ctx.Fragments = NewFragments(inv, tmpl, ctx.Flags.FontURL)
+	}
+	urls := []string{
+		"/css/?family=Amaranth:400,700&format=woff",
+		"/css/?family=Open+Sans:400,700&format=woff",
+	}
+	var reqs []*http.Request
+	for _, u := range urls {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		reqs = append(reqs, req)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		w := httptest.NewRecorder()
+		CssHandler(w, reqs[n%len(reqs)], ctx)
+		if w.Code != http.StatusOK {
+			b.Fatalf("status code %d", w.Code)
+		}
+	}
+}
+
+func BenchmarkCssHandler(b *testing.B) {
+	benchmarkCssHandler(b, false)
+}
+
+func BenchmarkCssHandlerFragments(b *testing.B) {
+	benchmarkCssHandler(b, true)
+}
+
+func TestAllowMethod(t *testing.T) {
+	wl := whitelist.New()
+	wl.Domains = append(wl.Domains, "http://one/")
+
+	var cases = []struct {
+		Method        string
+		AcAllowOrigin bool
+		Origin        string
+		Allow         bool
+		StatusCode    int
+		Header        map[string]string
+	}{
+		// Case 1
+		{"GET", false, "", true, http.StatusOK, map[string]string{
+			"Allow": "",
+		}},
+		// Case 2
+		{"HEAD", true, "http://one", true, http.StatusOK, map[string]string{
+			"Access-Control-Allow-Methods": "",
+		}},
+		// Case 3
+		{"OPTIONS", false, "http://one", false, http.StatusNoContent, map[string]string{
+			"Allow":                        "GET, HEAD, OPTIONS",
+			"Access-Control-Allow-Methods": "",
+			"Access-Control-Max-Age":       "",
+		}},
+		// Case 4
+		{"OPTIONS", true, "http://one", false, http.StatusNoContent, map[string]string{
+			"Allow":                        "GET, HEAD, OPTIONS",
+			"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS",
+			"Access-Control-Allow-Headers": "If-Modified-Since, If-None-Match, Range",
+			"Access-Control-Max-Age":       "86400",
+		}},
+		// Case 5
+		{"OPTIONS", true, "http://two", false, http.StatusNoContent, map[string]string{
+			"Allow":                        "GET, HEAD, OPTIONS",
+			"Access-Control-Allow-Methods": "",
+			"Access-Control-Max-Age":       "",
+		}},
+		// Case 6
+		{"POST", true, "http://one", false, http.StatusMethodNotAllowed, map[string]string{
+			"Allow":                        "GET, HEAD, OPTIONS",
+			"Access-Control-Allow-Methods": "",
+		}},
+		// Case 7
+		{"DELETE", false, "", false, http.StatusMethodNotAllowed, map[string]string{
+			"Allow": "GET, HEAD, OPTIONS",
+		}},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		ctx := HandlerContext{
+			Flags:     Flags{AcAllowOrigin: c.AcAllowOrigin},
+			Whitelist: *wl,
+		}
+		req, err := http.NewRequest(c.Method, "/css/?family=Amaranth", nil)
+		test.VerifyFatal(t, 1, j, true, nil == err)
+		if c.Origin != "" {
+			req.Header.Set("Origin", c.Origin)
+		}
+		w := httptest.NewRecorder()
+		test.Verify(t, 2, j, c.Allow, AllowMethod(w, req, ctx))
+		test.Verify(t, 3, j, c.StatusCode, w.Code)
+		for name, value := range c.Header {
+			test.Verify(t, 4, j, value, w.Header().Get(name))
+		}
+	}
+}
+
+func TestMatchEtag(t *testing.T) {
+	var cases = []struct {
+		IfNoneMatch string
+		Etag        string
+		Match       bool
+	}{
+		// Case 1
+		{"", `"abc"`, false},
+		// Case 2
+		{`"abc"`, `"abc"`, true},
+		// Case 3
+		{`"abd"`, `"abc"`, false},
+		// Case 4
+		{"abc", `"abc"`, false},
+		// Case 5
+		{`W/"abc"`, `"abc"`, true},
+		// Case 6
+		{`"abc"`, `W/"abc"`, true},
+		// Case 7
+		{"*", `"abc"`, true},
+		// Case 8
+		{`"xyz", "abc"`, `"abc"`, true},
+		// Case 9
+		{` "xyz" ,W/"abc+gzip"`, `"abc+gzip"`, true},
+		// Case 10
+		{`"a,b", "c"`, `"c"`, true},
+		// Case 11
+		{`"a,b", "c"`, `"b"`, false},
+		// Case 12
+		{`"xyz", abc, "abc"`, `"abc"`, false},
+		// Case 13
+		{`"abc`, `"abc"`, false},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		test.Verify(t, 1, j, c.Match, MatchEtag(c.IfNoneMatch, c.Etag))
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	var cases = []struct {
+		Text       string
+		Normalized string
+	}{
+		// Case 1
+		{"", ""},
+		// Case 2
+		{"Hello", "Helo"},
+		// Case 3
+		{"ÿ€a\ta", "aÿ€"},
+		// Case 4
+		{"b\xffa", "ab"},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		test.Verify(t, 1, j, c.Normalized, NormalizeText(c.Text))
+	}
+}
+
+func TestSubset(t *testing.T) {
+	ar := &font.Font{
+		Format: font.WOFF,
+		Path:   filepath.Join(amf, "amaranth-regular.woff"),
+	}
+	are := &font.Font{
+		Format: font.EOT,
+		Path:   filepath.Join(amf, "amaranth-regular.eot"),
+	}
+	arData, err := ar.Contents()
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	areData, err := are.Contents()
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+	arSubset, err := ar.Subset([]rune("Hi"))
+	test.VerifyFatal(t, 3, 0, true, nil == err)
+	arl := &font.Font{
+		Format:       font.WOFF,
+		Generate:     true,
+		Path:         ar.Path,
+		SubsetName:   "custom",
+		UnicodeRange: "U+48, U+69",
+	}
+
+	c := cache.New(1 << 20)
+	var cases = []struct {
+		Data     []byte
+		Font     *font.Font
+		Text     string
+		CacheLen int
+	}{
+		// Case 1
+		{arData, ar, "", 0},
+		// Case 2
+		{arSubset, ar, "Hi", 1},
+		// Case 3
+		{arSubset, ar, "Hi", 1},
+		// Case 4
+		{areData, are, "Hi", 1},
+		// Case 5
+		{arSubset, arl, "", 2},
+	}
+
+	for i, cs := range cases {
+		j := i + 1
+		data, err := Subset(cs.Font, cs.Text, HandlerContext{Subsets: c})
+		test.VerifyFatal(t, 1, j, true, nil == err)
+		test.Verify(t, 2, j, true, bytes.Equal(cs.Data, data))
+		test.Verify(t, 3, j, cs.CacheLen, c.Len())
+	}
+}
+
+func TestSplitSubsets(t *testing.T) {
+	latin := &font.Font{Format: font.WOFF, SubsetName: "latin"}
+	greek := &font.Font{Format: font.WOFF, SubsetName: "greek"}
+	eotGreek := &font.Font{Format: font.EOT, SubsetName: "greek"}
+	eot := &font.Font{Format: font.EOT, Subsets: []*font.Font{eotGreek}}
+	woff := &font.Font{Format: font.WOFF, Subsets: []*font.Font{latin, greek}}
+	ttf := &font.Font{Format: font.TTF}
+
+	var cases = []struct {
+		Fonts     []*font.Font
+		Names     []string
+		FaceFonts [][]*font.Font
+	}{
+		// Case 1
+		{
+			Fonts:     []*font.Font{ttf},
+			FaceFonts: [][]*font.Font{{ttf}},
+		},
+		// Case 2
+		{
+			Fonts:     []*font.Font{woff},
+			FaceFonts: [][]*font.Font{{latin}, {greek}},
+		},
+		// Case 3
+		{
+			Fonts: []*font.Font{eot, woff},
+			FaceFonts: [][]*font.Font{
+				{eot},
+				{eotGreek, greek},
+				{latin},
+			},
+		},
+		// Case 4
+		{
+			Fonts:     []*font.Font{eot, woff},
+			Names:     []string{"latin"},
+			FaceFonts: [][]*font.Font{{eot}, {latin}},
+		},
+		// Case 5
+		{
+			Fonts:     []*font.Font{woff},
+			Names:     []string{"cyrillic"},
+			FaceFonts: [][]*font.Font{{woff}},
+		},
+		// Case 6
+		{
+			Fonts:     []*font.Font{ttf, woff},
+			FaceFonts: [][]*font.Font{{ttf}, {latin}, {greek}},
+		},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		gFaceFonts := SplitSubsets(c.Fonts, c.Names)
+		test.VerifyFatal(t, 1, j, len(c.FaceFonts), len(gFaceFonts))
+		for k, wFonts := range c.FaceFonts {
+			test.VerifyFatal(t, 2, j, len(wFonts), len(gFaceFonts[k]))
+			for l, wFont := range wFonts {
+				test.Verify(t, 3, j, wFont, gFaceFonts[k][l])
+			}
+		}
+	}
+}
+
+func TestCssHandlerSubsets(t *testing.T) {
+	// Build a font library containing a subfamily split into
+	// subsets generated from its WOFF font file only.
+	library, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(library)
+	family := filepath.Join(library, "amaranth")
+	err = os.Mkdir(family, 0755)
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+	var eotSize int
+	for _, name := range []string{"amaranth-regular.eot", "amaranth-regular.woff"} {
+		data, err := ioutil.ReadFile(filepath.Join(amf, name))
+		test.VerifyFatal(t, 3, 0, true, nil == err)
+		if filepath.Ext(name) == ".eot" {
+			eotSize = len(util.Base64(data))
+		}
+		err = ioutil.WriteFile(filepath.Join(family, name), data, 0644)
+		test.VerifyFatal(t, 4, 0, true, nil == err)
+	}
+	metadata := `{
+		"family": "Amaranth",
+		"subfamilies": [
+			{
+				"basename": "amaranth-regular",
+				"formats": ["eot", "woff"],
+				"style": "normal",
+				"weight": 400,
+				"subsets": [
+					{"name": "latin"},
+					{"name": "latin-ext"},
+					{"name": "greek"}
+				]
+			}
+		]
+	}`
+	err = ioutil.WriteFile(filepath.Join(family, "metadata.json"),
+		[]byte(metadata), 0644)
+	test.VerifyFatal(t, 5, 0, true, nil == err)
+	inv := inventory.New()
+	err = inv.Build(library)
+	test.VerifyFatal(t, 6, 0, true, nil == err)
+	aawl := whitelist.New()
+	aawl.Domains = append(aawl.Domains, "")
+	tmpl, err := template.ParseFiles(filepath.Join(tp, "all.css.tmpl"))
+	test.VerifyFatal(t, 7, 0, true, nil == err)
+	ctx := HandlerContext{
+		Inventory: *inv,
+		Templates: *tmpl,
+		Whitelist: *aawl,
+	}
+
+	req, err := http.NewRequest("GET",
+		"/css/?family=Amaranth&format=all&delivery=inline", nil)
+	test.VerifyFatal(t, 8, 0, true, nil == err)
+	w := httptest.NewRecorder()
+	CssHandler(w, req, ctx)
+	test.VerifyFatal(t, 9, 0, http.StatusOK, w.Code)
+	body := w.Body.String()
+
+	// The EOT font file, which can't be subset, is embedded
+	// once, in a font face without Unicode range, followed by
+	// the font faces of the WOFF subsets.
+	eotSource := "url(data:application/vnd.ms-fontobject;"
+	test.Verify(t, 10, 0, 4, strings.Count(body, "@font-face"))
+	test.Verify(t, 11, 0, 3, strings.Count(body, "unicode-range:"))
+	test.Verify(t, 12, 0, 4, strings.Count(body, "url(data:"))
+	test.Verify(t, 13, 0, 1, strings.Count(body, eotSource))
+	test.Verify(t, 14, 0, true,
+		strings.Index(body, eotSource) < strings.Index(body, "unicode-range:"))
+	test.Verify(t, 15, 0, true, len(body) < 2*eotSize)
+}
+
 func TestQueries(t *testing.T) {
 	for i, c := range QueriesCases {
 		j := i + 1
@@ -572,7 +1998,7 @@ func TestQueries(t *testing.T) {
 		for k, wquery := range c.Queries {
 			gquery := gqueries[k]
 			test.Verify(t, 2, j, wquery.RowKey, gquery.RowKey)
-			test.Verify(t, 3, j, gquery.ColumnKey, gquery.ColumnKey)
+			test.Verify(t, 3, j, wquery.ColumnKey, gquery.ColumnKey)
 		}
 	}
 }
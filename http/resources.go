@@ -0,0 +1,108 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/noll/mjau/inventory"
+	"github.com/noll/mjau/whitelist"
+)
+
+// Resources holds the resources of the handlers which may be reloaded while
+// the server is running. Each resource is replaced atomically, and a handler
+// made by MakeHandler uses the resources current when the request was
+// received until the response is sent, so that a request being served has a
+// consistent view of them.
+type Resources struct {
+	fragments atomic.Value // *Fragments
+	inventory atomic.Value // *inventory.Inventory
+	templates atomic.Value // *templates
+	whitelist atomic.Value // *whitelist.Whitelist
+}
+
+// templates holds the templates along with their digest and the newest
+// modification time of their files.
+type templates struct {
+	digest  string
+	modtime time.Time
+	t       *template.Template
+}
+
+// NewResources creates and returns a new set of resources holding the given
+// font inventory, templates, modified at the given time, and whitelist.
+func NewResources(inv *inventory.Inventory, t *template.Template,
+	modtime time.Time, wl *whitelist.Whitelist) *Resources {
+	rs := new(Resources)
+	rs.SetInventory(inv)
+	rs.SetTemplates(t, modtime)
+	rs.SetWhitelist(wl)
+	return rs
+}
+
+// Fragments returns the current precomputed CSS fragments, or nil if there is
+// none.
+func (rs *Resources) Fragments() *Fragments {
+	fr, _ := rs.fragments.Load().(*Fragments)
+	return fr
+}
+
+// SetFragments replaces the precomputed CSS fragments. The fragments are used
+// only along with the font inventory and the templates they were precomputed
+// from.
+func (rs *Resources) SetFragments(fr *Fragments) {
+	rs.fragments.Store(fr)
+}
+
+// Inventory returns the current font inventory.
+func (rs *Resources) Inventory() *inventory.Inventory {
+	return rs.inventory.Load().(*inventory.Inventory)
+}
+
+// SetInventory replaces the font inventory.
+func (rs *Resources) SetInventory(inv *inventory.Inventory) {
+	rs.inventory.Store(inv)
+}
+
+// Templates returns the current templates.
+func (rs *Resources) Templates() *template.Template {
+	return rs.templates.Load().(*templates).t
+}
+
+// SetTemplates replaces the templates, along with the newest modification
+// time of their files. The templates must have been checked using
+// CheckTemplates.
+func (rs *Resources) SetTemplates(t *template.Template, modtime time.Time) {
+	rs.templates.Store(&templates{
+		digest:  TemplatesDigest(t),
+		modtime: modtime,
+		t:       t,
+	})
+}
+
+// Whitelist returns the current whitelist.
+func (rs *Resources) Whitelist() *whitelist.Whitelist {
+	return rs.whitelist.Load().(*whitelist.Whitelist)
+}
+
+// SetWhitelist replaces the whitelist.
+func (rs *Resources) SetWhitelist(wl *whitelist.Whitelist) {
+	rs.whitelist.Store(wl)
+}
+
+// context returns a copy of the given handler context using the current
+// resources.
+func (rs *Resources) context(ctx HandlerContext) HandlerContext {
+	templates := rs.templates.Load().(*templates)
+	ctx.Fragments = rs.Fragments()
+	ctx.Inventory = *rs.Inventory()
+	ctx.Templates = *templates.t
+	ctx.TemplatesDigest = templates.digest
+	ctx.TemplatesModTime = templates.modtime
+	ctx.Whitelist = *rs.Whitelist()
+	return ctx
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/noll/mjau/inventory"
+	"github.com/noll/mjau/test"
+	"github.com/noll/mjau/whitelist"
+)
+
+func TestResources(t *testing.T) {
+	inv := inventory.New()
+	err := inv.Build(fl)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	empty := inventory.New()
+	wl := whitelist.New()
+	wl.Domains = append(wl.Domains, "http://example.com/")
+
+	tmpl := template.Must(template.New("a").Parse("a"))
+
+	var lens, sizes []int
+	var digests []string
+	var modtimes []time.Time
+	handler := func(w http.ResponseWriter, r *http.Request,
+		ctx HandlerContext) {
+		lens = append(lens, ctx.Inventory.Len())
+		sizes = append(sizes, ctx.Whitelist.Size())
+		digests = append(digests, ctx.TemplatesDigest)
+		modtimes = append(modtimes, ctx.TemplatesModTime)
+	}
+	resources := NewResources(empty, template.New("empty"), time.Time{},
+		whitelist.New())
+	ctx := HandlerContext{
+		Inventory: *inv,
+		Resources: resources,
+		Whitelist: *wl,
+	}
+	fn := MakeHandler(handler, ctx)
+
+	req, err := http.NewRequest("GET", "/", nil)
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+	fn(httptest.NewRecorder(), req)
+	resources.SetInventory(inv)
+	modtime := time.Now()
+	resources.SetTemplates(tmpl, modtime)
+	resources.SetWhitelist(wl)
+	test.Verify(t, 3, 0, inv, resources.Inventory())
+	test.Verify(t, 4, 0, wl, resources.Whitelist())
+	test.Verify(t, 4, 1, tmpl, resources.Templates())
+	fn(httptest.NewRecorder(), req)
+
+	test.VerifyFatal(t, 5, 0, 2, len(lens))
+	test.Verify(t, 6, 0, 0, lens[0])
+	test.Verify(t, 7, 0, inv.Len(), lens[1])
+	test.Verify(t, 8, 0, true, inv.Len() > 0)
+	test.Verify(t, 9, 0, 0, sizes[0])
+	test.Verify(t, 10, 0, 1, sizes[1])
+	test.Verify(t, 11, 0, TemplatesDigest(template.New("empty")), digests[0])
+	test.Verify(t, 12, 0, TemplatesDigest(tmpl), digests[1])
+	test.Verify(t, 13, 0, false, digests[0] == digests[1])
+	test.Verify(t, 14, 0, true, modtimes[0].IsZero())
+	test.Verify(t, 15, 0, modtime, modtimes[1])
+}
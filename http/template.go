@@ -0,0 +1,98 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"text/template"
+
+	"github.com/noll/mjau/font"
+)
+
+// TemplateNames returns the names of the templates used by CssHandler: the
+// template of all the font formats followed by the template of each font
+// format.
+func TemplateNames() []string {
+	names := []string{"all.css.tmpl"}
+	for _, format := range font.Formats {
+		names = append(names, format.String()+".css.tmpl")
+	}
+	return names
+}
+
+// CheckTemplates executes the templates used by CssHandler against sample
+// font faces, embedded and linked, and returns the errors which occurred.
+func CheckTemplates(t *template.Template) (errs []error) {
+	for _, name := range TemplateNames() {
+		for _, data := range sampleFontFaces() {
+			if err := t.ExecuteTemplate(ioutil.Discard, name, data); err != nil {
+				errs = append(errs, fmt.Errorf("template %s: %s", name, err))
+				break
+			}
+		}
+	}
+	return
+}
+
+// TemplatesDigest returns a digest of the contents of the given templates,
+// which changes whenever any of them is modified.
+func TemplatesDigest(t *template.Template) string {
+	templates := t.Templates()
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name() < templates[j].Name()
+	})
+	hash := md5.New()
+	for _, tmpl := range templates {
+		io.WriteString(hash, tmpl.Name()+"\x00")
+		if tmpl.Tree != nil && tmpl.Tree.Root != nil {
+			io.WriteString(hash, tmpl.Tree.Root.String())
+		}
+		io.WriteString(hash, "\x00")
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// sampleFontFaces returns sample template data, using all the fields of the
+// font faces and sources.
+func sampleFontFaces() [][]*FontFace {
+	embedded := &Source{
+		Base64Data: "d09GRgABAAA=",
+		CssFormat:  "woff",
+		Format:     "woff",
+		MimeType:   "application/x-font-woff",
+	}
+	eot := &Source{
+		CssFormat: "embedded-opentype",
+		Format:    "eot",
+		MimeType:  "application/vnd.ms-fontobject",
+		URL:       "/font/Sample/400normal.latin.eot",
+	}
+	woff := &Source{
+		CssFormat: "woff",
+		Format:    "woff",
+		MimeType:  "application/x-font-woff",
+		URL:       "/font/Sample/400normal.latin.woff",
+	}
+	sample := font.Font{
+		Axes: []font.Axis{
+			{Tag: "wght", Min: 100, Default: 400, Max: 900},
+			{Tag: "wdth", Min: 75, Default: 100, Max: 100},
+		},
+		Family:       "Sample",
+		Style:        "normal",
+		SubsetName:   "latin",
+		UnicodeRange: font.UnicodeRanges["latin"],
+		Weight:       400,
+	}
+	inline, link := new(FontFace), new(FontFace)
+	inline.FromSources(font.Font{Family: "Sample", Style: "italic", Weight: 700},
+		[]*Source{embedded})
+	link.FromSources(sample, []*Source{eot, woff})
+	return [][]*FontFace{{inline}, {link}, {inline, link}}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package http
+
+import (
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/noll/mjau/test"
+)
+
+func TestCheckTemplates(t *testing.T) {
+	var filenames []string
+	for _, name := range TemplateNames() {
+		filenames = append(filenames, filepath.Join(tp, name))
+	}
+	tmpl, err := template.ParseFiles(filenames...)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	test.Verify(t, 2, 0, 0, len(CheckTemplates(tmpl)))
+
+	// A template using an unknown field, and
+	// a missing template.
+	tmpl, err = template.New("all.css.tmpl").Parse("{{range .}}{{.Nope}}{{end}}")
+	test.VerifyFatal(t, 3, 0, true, nil == err)
+	test.Verify(t, 4, 0, len(TemplateNames()), len(CheckTemplates(tmpl)))
+}
+
+func TestTemplatesDigest(t *testing.T) {
+	var cases = []struct {
+		Texts  map[string]string
+		Digest int // Index of the case with the same digest.
+	}{
+		// Case 1
+		{map[string]string{"a": "{{.}}", "b": "b"}, 0},
+		// Case 2
+		{map[string]string{"b": "b", "a": "{{.}}"}, 0},
+		// Case 3
+		{map[string]string{"a": "{{.}};", "b": "b"}, 2},
+		// Case 4
+		{map[string]string{"a": "{{.}}b"}, 3},
+		// Case 5
+		{map[string]string{"a": "{{.}}", "b": "b", "c": ""}, 4},
+	}
+
+	var digests []string
+	for i, c := range cases {
+		j := i + 1
+		tmpl := template.New("")
+		for name, text := range c.Texts {
+			_, err := tmpl.New(name).Parse(text)
+			test.VerifyFatal(t, 1, j, true, nil == err)
+		}
+		digest := TemplatesDigest(tmpl)
+		digests = append(digests, digest)
+		test.Verify(t, 2, j, digests[c.Digest], digest)
+		for k := 0; k < c.Digest; k++ {
+			test.Verify(t, 3, j, false, digests[k] == digest)
+		}
+	}
+}
@@ -5,21 +5,9 @@
 package http
 
 import (
-	"bytes"
-	"compress/gzip"
 	"net/http"
-	"strings"
 )
 
-type gzipResponseWriter struct {
-	buf bytes.Buffer
-	http.ResponseWriter
-}
-
-func (w *gzipResponseWriter) Write(b []byte) (int, error) {
-	return w.buf.Write(b)
-}
-
 // BadRequest sends an HTTP response header
 // with 400 bad request status code.
 func BadRequest(w http.ResponseWriter, r *http.Request) {
@@ -38,28 +26,18 @@ func InternalServerError(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusInternalServerError)
 }
 
-// MakeGzipHandler is a http handler wrapper which applies gzip compression
-// to the response body generated by the wrapped http handler.
-func MakeGzipHandler(fn http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		acceptEncoding := r.Header.Get("Accept-Encoding")
-		if !strings.Contains(acceptEncoding, "gzip") {
-			fn(w, r)
-			return
-		}
-		w.Header().Set("Content-Encoding", "gzip")
-		gw := &gzipResponseWriter{ResponseWriter: w}
-		fn(gw, r)
-		gzipWriter := gzip.NewWriter(w)
-		defer gzipWriter.Close()
-		gw.buf.WriteTo(gzipWriter)
-	}
+// MethodNotAllowed sends an HTTP response header
+// with 405 method not allowed status code, along
+// with the methods allowed by the handlers.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", AllowedMethods)
+	w.WriteHeader(http.StatusMethodNotAllowed)
 }
 
-// NotImplemented sends an HTTP response header
-// with 501 not implemented status code.
-func NotImplemented(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotImplemented)
+// NotFound sends an HTTP response header
+// with 404 not found status code.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotFound)
 }
 
 // NotModified sends an HTTP response header
@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/noll/mjau/font"
 	"github.com/noll/mjau/util"
@@ -17,9 +19,14 @@ import (
 	"github.com/noll/samling/table"
 )
 
-// Inventory represents a table for storing fonts.
+// Inventory represents a table for storing fonts. The fonts are also indexed
+// by font family name, in order to resolve queries for the weights covered by
+// variable fonts and to find the nearest matches of queries.
 type Inventory struct {
 	*table.Table
+	Errors   []error // Invalid entries excluded by Build.
+	Strict   bool    // Invalid entries are fatal to Build.
+	families map[string][]*font.Font
 }
 
 // Query represents an inventory query.
@@ -28,9 +35,15 @@ type Query struct {
 	ColumnKey string
 }
 
-// Build builds the inventory using the JSON-encoded metadata files from the
-// first level subdirectories of the named directory. Returns an error if the
-// named directory is not a directory, or if it cannot be read.
+// Build builds the inventory using the font families from the first level
+// subdirectories of the named directory. The metadata of a font family is
+// derived from its font files and is overridden by its JSON-encoded metadata
+// file, if present. Invalid metadata files and font files, problems found in
+// the metadata, font families defined in several directories, and subfamilies
+// sharing the same weight and style are excluded and reported in Errors,
+// unless the inventory is strict. Returns an error if the
+// named directory is not a directory, or if it cannot be read, or if the
+// inventory is strict and an invalid entry is found.
 func (i *Inventory) Build(name string) error {
 	if !util.IsDir(name) {
 		return fmt.Errorf("%s: not a directory", name)
@@ -39,40 +52,339 @@ func (i *Inventory) Build(name string) error {
 	if err != nil {
 		return err
 	}
+	// Directories of the font families.
+	dirs := make(map[string]string)
 	for _, entry := range entries {
-		mjson := filepath.Join(name, entry.Name(), "metadata.json")
-		if !(entry.IsDir() && util.Exists(mjson)) {
-			// No metadata file, skip entry.
-			// TODO: Add logging.
+		if !entry.IsDir() {
 			continue
 		}
+		dir := filepath.Join(name, entry.Name())
+		mjson := filepath.Join(dir, "metadata.json")
+		hasMetadata := util.Exists(mjson)
 		metadata := new(font.Metadata)
-		if err := metadata.Read(mjson); err != nil {
-			// Invalid metadata file, skip font family.
-			// TODO: Add logging.
+		if hasMetadata {
+			if err := metadata.Read(mjson); err != nil {
+				// Invalid metadata file, skip font family.
+				if err := i.invalid(err); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		scanned := new(font.Metadata)
+		if err := scanned.Scan(dir); err == nil {
+			metadata.Merge(scanned)
+		} else if !hasMetadata {
+			// No metadata file and no font information
+			// in the font files, skip entry. Directories
+			// without any font file are not font families,
+			// they are skipped without being reported.
+			if err == font.ErrNoFontFiles {
+				continue
+			}
+			if err := i.invalid(err); err != nil {
+				return err
+			}
+			continue
+		}
+		if metadata.Family == "" {
+			// No font family name, skip entry.
+			err := fmt.Errorf("%s: no font family name", dir)
+			if err := i.invalid(err); err != nil {
+				return err
+			}
+			continue
+		}
+		if other, ok := dirs[metadata.Family]; ok {
+			// Font family already defined, skip entry.
+			err := fmt.Errorf("%s: font family %q already defined in %s",
+				dir, metadata.Family, other)
+			if err := i.invalid(err); err != nil {
+				return err
+			}
 			continue
 		}
+		dirs[metadata.Family] = dir
+		for _, err := range metadata.Check() {
+			if err := i.invalid(err); err != nil {
+				return err
+			}
+		}
 		fonts := metadata.Fonts()
-		for _, font := range fonts {
-			format := font.Format.String()
-			weight := strconv.Itoa(font.Weight)
-			columnKey := format + weight + font.Style
-			i.Put(font.Family, columnKey, font)
+		for _, fnt := range fonts {
+			if err := fnt.Validate(); err != nil {
+				// Invalid font file, skip font.
+				if err := i.invalid(err); err != nil {
+					return err
+				}
+				continue
+			}
+			var subsets []*font.Font
+			for _, subset := range fnt.Subsets {
+				if subset.Path != fnt.Path {
+					if err := subset.Validate(); err != nil {
+						// Invalid subset font file, skip subset.
+						if err := i.invalid(err); err != nil {
+							return err
+						}
+						continue
+					}
+				}
+				subsets = append(subsets, subset)
+			}
+			fnt.Subsets = subsets
+			format := fnt.Format.String()
+			weight := strconv.Itoa(fnt.Weight)
+			columnKey := format + weight + fnt.Style
+			if i.Get(fnt.Family, columnKey) != nil {
+				// Weight and style already defined, as
+				// reported by Check, skip font.
+				continue
+			}
+			i.Put(fnt.Family, columnKey, fnt)
+			i.families[fnt.Family] = append(i.families[fnt.Family], fnt)
 		}
 	}
 	return nil
 }
 
+// invalid reports the given error about an invalid entry, which is returned
+// if the inventory is strict, or added to the errors otherwise.
+func (i *Inventory) invalid(err error) error {
+	if i.Strict {
+		return err
+	}
+	i.Errors = append(i.Errors, err)
+	return nil
+}
+
+// Fonts returns all the fonts in the inventory, ordered by font family name.
+func (i *Inventory) Fonts() (fonts []*font.Font) {
+	var families []string
+	for family := range i.families {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+	for _, family := range families {
+		fonts = append(fonts, i.families[family]...)
+	}
+	return
+}
+
 // Query queries the inventory and returns the font which conforms to the
-// given query, or nil if there is no such font in the inventory.
+// given query, or nil if there is no such font in the inventory. The weight
+// from the column key of the query may also be a range of weights, such as
+// 100..900. If no font has the exact weight, the query is resolved to the
+// variable font whose weight range covers the requested weights.
 func (i *Inventory) Query(query Query) *font.Font {
 	if f := i.Get(query.RowKey, query.ColumnKey); f != nil {
 		return f.(*font.Font)
 	}
+	format, min, max, style, ok := ParseColumnKey(query.ColumnKey)
+	if !ok {
+		return nil
+	}
+	for _, f := range i.families[query.RowKey] {
+		if f.Axis("wght") == nil {
+			continue
+		}
+		fMin, fMax := f.WeightRange()
+		if f.Format == format && f.Style == style &&
+			fMin <= min && max <= fMax {
+			return f
+		}
+	}
 	return nil
 }
 
+// Nearest returns the query, without font format, of the font of the queried
+// font family which is the nearest match of the given query among the fonts
+// available in one of the given font formats, and reports whether there is
+// such a font. The font is chosen like the CSS font matching algorithm does:
+// first by style, preferring italic over oblique for italic queries, then by
+// the closest weight in the direction given by the queried weight.
+func (i *Inventory) Nearest(query Query, formats []font.Format) (Query, bool) {
+	_, weight, _, style, ok := ParseColumnKey(query.ColumnKey)
+	if !ok {
+		return Query{}, false
+	}
+	var nearest *font.Font
+	var nearestWeight int
+	var nearestRank [3]int
+	for _, f := range i.families[query.RowKey] {
+		if !hasFormat(formats, f.Format) {
+			continue
+		}
+		// Variable fonts are used at the
+		// closest weight they cover.
+		min, max := f.WeightRange()
+		w := weight
+		if w < min {
+			w = min
+		} else if w > max {
+			w = max
+		}
+		group, distance := weightRank(weight, w)
+		rank := [3]int{styleRank(style, f.Style), group, distance}
+		if nearest == nil || rankLess(rank, nearestRank) {
+			nearest, nearestWeight, nearestRank = f, w, rank
+		}
+	}
+	if nearest == nil {
+		return Query{}, false
+	}
+	q := Query{
+		RowKey:    query.RowKey,
+		ColumnKey: strconv.Itoa(nearestWeight) + nearest.Style,
+	}
+	return q, true
+}
+
+// QueryAll queries the inventory and returns the fonts which conform to the
+// given query in all the supported font formats, ordered as font.Formats.
+// The column key of the query must not contain the font format.
+func (i *Inventory) QueryAll(query Query) (fonts []*font.Font) {
+	for _, format := range font.Formats {
+		q := Query{
+			RowKey:    query.RowKey,
+			ColumnKey: format.String() + query.ColumnKey,
+		}
+		if f := i.Query(q); f != nil {
+			fonts = append(fonts, f)
+		}
+	}
+	return
+}
+
+// QueryPreferred queries the inventory and returns the font which conforms to
+// the given query in the first of the given font formats available in the
+// inventory, or nil if there is no such font in the inventory.
+// The column key of the query must not contain the font format.
+func (i *Inventory) QueryPreferred(query Query, formats []font.Format) *font.Font {
+	for _, format := range formats {
+		q := Query{
+			RowKey:    query.RowKey,
+			ColumnKey: format.String() + query.ColumnKey,
+		}
+		if f := i.Query(q); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// hasFormat reports whether the given font formats contain the given font
+// format.
+func hasFormat(formats []font.Format, format font.Format) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// rankLess reports whether rank a is better than rank b.
+func rankLess(a, b [3]int) bool {
+	for k := range a {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return false
+}
+
+// styleRank ranks the given available style for the given desired style,
+// lower is better. Italic is preferred over oblique for italic queries, and
+// unknown styles are treated as normal.
+func styleRank(desired, available string) int {
+	var order []string
+	switch desired {
+	case "italic":
+		order = []string{"italic", "oblique", "normal"}
+	case "oblique":
+		order = []string{"oblique", "italic", "normal"}
+	default:
+		order = []string{"normal", "oblique", "italic"}
+	}
+	for k, style := range order {
+		if style == available {
+			return k
+		}
+	}
+	return len(order)
+}
+
+// weightRank ranks the given available weight for the given desired weight,
+// lower group then lower distance is better. Weights between 400 and 500 look
+// for heavier weights up to 500 first, then for lighter weights, then for
+// heavier weights. Lighter weights look for lighter weights first, and bolder
+// weights look for bolder weights first.
+func weightRank(desired, available int) (group, distance int) {
+	distance = available - desired
+	if distance < 0 {
+		distance = -distance
+	}
+	switch {
+	case available == desired:
+		return 0, 0
+	case desired >= 400 && desired <= 500:
+		if available > desired && available <= 500 {
+			return 1, distance
+		}
+		if available < desired {
+			return 2, distance
+		}
+		return 3, distance
+	case desired < 400:
+		if available < desired {
+			return 1, distance
+		}
+		return 2, distance
+	default:
+		if available > desired {
+			return 1, distance
+		}
+		return 2, distance
+	}
+}
+
+// ParseColumnKey parses the given column key, made of an optional font format,
+// a weight or a range of weights, and a style, as in woff400italic or
+// 100..900normal. Reports whether the column key could be parsed.
+func ParseColumnKey(key string) (format font.Format, min, max int, style string, ok bool) {
+	for _, f := range font.Formats {
+		if strings.HasPrefix(key, f.String()) {
+			format = f
+			key = strings.TrimPrefix(key, f.String())
+			break
+		}
+	}
+	i := strings.IndexFunc(key, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i <= 0 {
+		return
+	}
+	weights, style := key[:i], key[i:]
+	sMin, sMax := weights, weights
+	if j := strings.Index(weights, ".."); j >= 0 {
+		sMin, sMax = weights[:j], weights[j+2:]
+	}
+	var err error
+	if min, err = strconv.Atoi(sMin); err != nil {
+		return
+	}
+	if max, err = strconv.Atoi(sMax); err != nil || max < min {
+		return
+	}
+	return format, min, max, style, true
+}
+
 // New creates and returns a new (empty) inventory.
 func New() *Inventory {
-	return &Inventory{table.New()}
+	return &Inventory{
+		Table:    table.New(),
+		families: make(map[string][]*font.Font),
+	}
 }
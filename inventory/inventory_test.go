@@ -5,7 +5,10 @@
 package inventory
 
 import (
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/noll/mjau/font"
@@ -439,6 +442,23 @@ func TestInventoryBuild(t *testing.T) {
 	}
 }
 
+func TestInventoryFonts(t *testing.T) {
+	inventory := New()
+	err := inventory.Build(fl)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	fonts := inventory.Fonts()
+	test.VerifyFatal(t, 2, 0, inventory.Len(), len(fonts))
+
+	for i, fnt := range fonts {
+		j := i + 1
+		if i > 0 {
+			test.Verify(t, 3, j, true, fonts[i-1].Family <= fnt.Family)
+		}
+		columnKey := fnt.Format.String() + strconv.Itoa(fnt.Weight) + fnt.Style
+		test.Verify(t, 4, j, fnt, inventory.Query(Query{fnt.Family, columnKey}))
+	}
+}
+
 func TestQueryQuery(t *testing.T) {
 	inventory := New()
 	query := Query{
@@ -468,3 +488,419 @@ func TestQueryQuery(t *testing.T) {
 	test.Verify(t, 6, 0, wFont.Weight, gFont.Weight)
 	test.Verify(t, 7, 0, wFont.Path, gFont.Path)
 }
+
+func TestInventoryQueryAll(t *testing.T) {
+	inventory := New()
+	err := inventory.Build(fl)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+
+	query := Query{
+		RowKey:    "Amaranth",
+		ColumnKey: "700italic",
+	}
+	gFonts := inventory.QueryAll(query)
+	wFonts := []*font.Font{
+		// Font 1
+		&font.Font{
+			Family: "Amaranth",
+			Format: font.EOT,
+			Path:   filepath.Join(amf, "amaranth-bolditalic.eot"),
+			Style:  "italic",
+			Weight: 700,
+		},
+		// Font 2
+		&font.Font{
+			Family: "Amaranth",
+			Format: font.WOFF,
+			Path:   filepath.Join(amf, "amaranth-bolditalic.woff"),
+			Style:  "italic",
+			Weight: 700,
+		},
+	}
+	test.VerifyFatal(t, 2, 0, len(wFonts), len(gFonts))
+	for i, wFont := range wFonts {
+		j := i + 1
+		gFont := gFonts[i]
+		test.Verify(t, 3, j, wFont.Family, gFont.Family)
+		test.Verify(t, 4, j, true, wFont.Format.Equal(gFont.Format))
+		test.Verify(t, 5, j, wFont.Style, gFont.Style)
+		test.Verify(t, 6, j, wFont.Weight, gFont.Weight)
+		test.Verify(t, 7, j, wFont.Path, gFont.Path)
+	}
+
+	query = Query{
+		RowKey:    "Amaranth",
+		ColumnKey: "900normal",
+	}
+	gFonts = inventory.QueryAll(query)
+	test.Verify(t, 8, 0, 0, len(gFonts))
+}
+
+func TestInventoryQueryPreferred(t *testing.T) {
+	inventory := New()
+	err := inventory.Build(fl)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+
+	query := Query{
+		RowKey:    "Open Sans",
+		ColumnKey: "600italic",
+	}
+	formats := []font.Format{font.WOFF2, font.EOT, font.WOFF}
+	gFont := inventory.QueryPreferred(query, formats)
+	test.VerifyFatal(t, 2, 0, false, nil == gFont)
+	test.Verify(t, 3, 0, true, gFont.Format.Equal(font.EOT))
+	wPath := filepath.Join(osf, "os-semibolditalic.eot")
+	test.Verify(t, 4, 0, wPath, gFont.Path)
+
+	formats = []font.Format{font.WOFF2, font.TTF}
+	gFont = inventory.QueryPreferred(query, formats)
+	test.Verify(t, 5, 0, true, nil == gFont)
+}
+
+func TestInventoryNearest(t *testing.T) {
+	inventory := New()
+	err := inventory.Build(fl)
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+
+	woff := []font.Format{font.WOFF}
+	var cases = []struct {
+		Query   Query
+		Formats []font.Format
+		Nearest string
+	}{
+		// Case 1
+		{Query{"Open Sans", "woff400normal"}, woff, "400normal"},
+		// Case 2
+		{Query{"Open Sans", "woff450normal"}, woff, "400normal"},
+		// Case 3
+		{Query{"Open Sans", "woff500normal"}, woff, "400normal"},
+		// Case 4
+		{Query{"Open Sans", "woff200normal"}, woff, "300normal"},
+		// Case 5
+		{Query{"Open Sans", "woff350italic"}, woff, "300italic"},
+		// Case 6
+		{Query{"Open Sans", "woff650normal"}, woff, "700normal"},
+		// Case 7
+		{Query{"Open Sans", "woff900italic"}, woff, "800italic"},
+		// Case 8
+		{Query{"Amaranth", "500oblique"}, font.Formats, "400italic"},
+		// Case 9
+		{Query{"Amaranth", "400misspelled"}, font.Formats, "400normal"},
+		// Case 10
+		{Query{"Amaranth", "400normal"}, []font.Format{font.TTF}, ""},
+		// Case 11
+		{Query{"Nonexistent", "400normal"}, woff, ""},
+		// Case 12
+		{Query{"Amaranth", "normal"}, woff, ""},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		gQuery, ok := inventory.Nearest(c.Query, c.Formats)
+		test.VerifyFatal(t, 1, j, c.Nearest != "", ok)
+		if !ok {
+			continue
+		}
+		test.Verify(t, 2, j, c.Query.RowKey, gQuery.RowKey)
+		test.Verify(t, 3, j, c.Nearest, gQuery.ColumnKey)
+	}
+}
+
+func TestInventoryBuildWithoutMetadata(t *testing.T) {
+	// Build a font library containing a font family
+	// without metadata file.
+	library, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(library)
+	family := filepath.Join(library, "amaranth")
+	err = os.Mkdir(family, 0755)
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+	for _, name := range []string{"amaranth-italic.eot", "amaranth-italic.woff"} {
+		data, err := ioutil.ReadFile(filepath.Join(amf, name))
+		test.VerifyFatal(t, 3, 0, true, nil == err)
+		err = ioutil.WriteFile(filepath.Join(family, name), data, 0644)
+		test.VerifyFatal(t, 4, 0, true, nil == err)
+	}
+
+	inventory := New()
+	err = inventory.Build(library)
+	test.VerifyFatal(t, 5, 0, true, nil == err)
+	test.VerifyFatal(t, 6, 0, 2, inventory.Len())
+
+	query := Query{
+		RowKey:    "Amaranth",
+		ColumnKey: "eot400italic",
+	}
+	gFont := inventory.Query(query)
+	test.VerifyFatal(t, 7, 0, false, nil == gFont)
+	wPath := filepath.Join(family, "amaranth-italic.eot")
+	test.Verify(t, 8, 0, wPath, gFont.Path)
+	test.Verify(t, 9, 0, true, gFont.Format.Equal(font.EOT))
+}
+
+func TestInventoryQueryVariable(t *testing.T) {
+	// Build a font library containing a variable font
+	// and a static font of the same font family.
+	library, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(library)
+	family := filepath.Join(library, "foo")
+	err = os.Mkdir(family, 0755)
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+	for _, name := range []string{"amaranth-regular.woff", "amaranth-bold.woff"} {
+		data, err := ioutil.ReadFile(filepath.Join(amf, name))
+		test.VerifyFatal(t, 3, 0, true, nil == err)
+		err = ioutil.WriteFile(filepath.Join(family, name), data, 0644)
+		test.VerifyFatal(t, 4, 0, true, nil == err)
+	}
+	metadata := `{
+		"family": "Foo",
+		"subfamilies": [
+			{
+				"basename": "amaranth-regular",
+				"axes": [{"tag": "wght", "min": 100, "max": 900}]
+			},
+			{
+				"basename": "amaranth-bold"
+			}
+		]
+	}`
+	err = ioutil.WriteFile(filepath.Join(family, "metadata.json"),
+		[]byte(metadata), 0644)
+	test.VerifyFatal(t, 5, 0, true, nil == err)
+
+	inventory := New()
+	err = inventory.Build(library)
+	test.VerifyFatal(t, 6, 0, true, nil == err)
+	test.VerifyFatal(t, 7, 0, 2, inventory.Len())
+
+	variable := filepath.Join(family, "amaranth-regular.woff")
+	static := filepath.Join(family, "amaranth-bold.woff")
+	var cases = []struct {
+		ColumnKey string
+		Path      string
+	}{
+		// Case 1
+		{"woff400normal", variable},
+		// Case 2
+		{"woff700normal", static},
+		// Case 3
+		{"woff550normal", variable},
+		// Case 4
+		{"woff100..900normal", variable},
+		// Case 5
+		{"woff300..600normal", variable},
+		// Case 6
+		{"woff50..900normal", ""},
+		// Case 7
+		{"woff550italic", ""},
+		// Case 8
+		{"eot550normal", ""},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		gFont := inventory.Query(Query{"Foo", c.ColumnKey})
+		if c.Path == "" {
+			test.Verify(t, 1, j, true, nil == gFont)
+			continue
+		}
+		test.VerifyFatal(t, 2, j, false, nil == gFont)
+		test.Verify(t, 3, j, c.Path, gFont.Path)
+	}
+}
+
+func TestParseColumnKey(t *testing.T) {
+	var cases = []struct {
+		ColumnKey string
+		Format    font.Format
+		Min       int
+		Max       int
+		Style     string
+		Ok        bool
+	}{
+		// Case 1
+		{"woff400italic", font.WOFF, 400, 400, "italic", true},
+		// Case 2
+		{"woff2100..900normal", font.WOFF2, 100, 900, "normal", true},
+		// Case 3
+		{"300normal", font.NOF, 300, 300, "normal", true},
+		// Case 4
+		{"eot900..100normal", font.EOT, 0, 0, "", false},
+		// Case 5
+		{"ttfnormal", font.TTF, 0, 0, "", false},
+		// Case 6
+		{"otf1..2..3normal", font.OTF, 0, 0, "", false},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		format, min, max, style, ok := ParseColumnKey(c.ColumnKey)
+		test.VerifyFatal(t, 1, j, c.Ok, ok)
+		if !ok {
+			continue
+		}
+		test.Verify(t, 2, j, true, format.Equal(c.Format))
+		test.Verify(t, 3, j, c.Min, min)
+		test.Verify(t, 4, j, c.Max, max)
+		test.Verify(t, 5, j, c.Style, style)
+	}
+}
+
+func TestInventoryBuildUnrelated(t *testing.T) {
+	// Build a font library containing a font family
+	// along with directories unrelated to fonts.
+	library, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(library)
+	woff, err := ioutil.ReadFile(filepath.Join(amf, "amaranth-italic.woff"))
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+	files := map[string][]byte{
+		filepath.Join("amaranth", "amaranth-italic.woff"): woff,
+		filepath.Join(".git", "HEAD"):                     []byte("ref: refs/heads/master\n"),
+		filepath.Join("docs", "README.md"):                []byte("# Fonts\n"),
+	}
+	for name, data := range files {
+		path := filepath.Join(library, name)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		test.VerifyFatal(t, 3, 0, true, nil == err)
+		err = ioutil.WriteFile(path, data, 0644)
+		test.VerifyFatal(t, 4, 0, true, nil == err)
+	}
+	err = os.Mkdir(filepath.Join(library, "empty"), 0755)
+	test.VerifyFatal(t, 5, 0, true, nil == err)
+
+	// The unrelated directories are skipped silently,
+	// even in strict mode.
+	inventory := New()
+	inventory.Strict = true
+	err = inventory.Build(library)
+	test.VerifyFatal(t, 6, 0, true, nil == err)
+	test.Verify(t, 7, 0, 1, inventory.Len())
+	test.Verify(t, 8, 0, 0, len(inventory.Errors))
+
+	// A directory containing a broken font file
+	// is still reported.
+	broken := filepath.Join(library, "broken")
+	err = os.Mkdir(broken, 0755)
+	test.VerifyFatal(t, 9, 0, true, nil == err)
+	err = ioutil.WriteFile(filepath.Join(broken, "broken.woff"),
+		[]byte("not a font"), 0644)
+	test.VerifyFatal(t, 10, 0, true, nil == err)
+	inventory = New()
+	err = inventory.Build(library)
+	test.VerifyFatal(t, 11, 0, true, nil == err)
+	test.Verify(t, 12, 0, 1, len(inventory.Errors))
+	inventory = New()
+	inventory.Strict = true
+	err = inventory.Build(library)
+	test.Verify(t, 13, 0, false, nil == err)
+}
+
+func TestInventoryBuildInvalid(t *testing.T) {
+	// Build a font library containing a missing font file
+	// and a font file of another font format than declared.
+	library, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(library)
+	family := filepath.Join(library, "amaranth")
+	err = os.Mkdir(family, 0755)
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+	files := map[string]string{
+		"amaranth-regular.woff": "amaranth-regular.woff",
+		"amaranth-bold.woff":    "amaranth-bold.eot",
+	}
+	for name, source := range files {
+		data, err := ioutil.ReadFile(filepath.Join(amf, source))
+		test.VerifyFatal(t, 3, 0, true, nil == err)
+		err = ioutil.WriteFile(filepath.Join(family, name), data, 0644)
+		test.VerifyFatal(t, 4, 0, true, nil == err)
+	}
+	metadata := `{
+		"family": "Amaranth",
+		"subfamilies": [
+			{
+				"basename": "amaranth-regular",
+				"formats": ["eot", "woff"],
+				"style": "normal",
+				"weight": 400
+			},
+			{
+				"basename": "amaranth-bold",
+				"formats": ["woff"],
+				"style": "normal",
+				"weight": 700
+			}
+		]
+	}`
+	err = ioutil.WriteFile(filepath.Join(family, "metadata.json"),
+		[]byte(metadata), 0644)
+	test.VerifyFatal(t, 5, 0, true, nil == err)
+
+	// Invalid entries are excluded.
+	inventory := New()
+	err = inventory.Build(library)
+	test.VerifyFatal(t, 6, 0, true, nil == err)
+	test.Verify(t, 7, 0, 1, inventory.Len())
+	test.Verify(t, 8, 0, 2, len(inventory.Errors))
+	gFont := inventory.Query(Query{"Amaranth", "woff400normal"})
+	test.Verify(t, 9, 0, false, nil == gFont)
+
+	// Invalid entries are fatal.
+	inventory = New()
+	inventory.Strict = true
+	err = inventory.Build(library)
+	test.Verify(t, 10, 0, false, nil == err)
+}
+
+func TestInventoryBuildDuplicates(t *testing.T) {
+	// Build a font library containing the same font family
+	// in two directories, and two subfamilies sharing the
+	// same weight and style.
+	library, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(library)
+	metadata := `{
+		"family": "Amaranth",
+		"subfamilies": [
+			{
+				"basename": "amaranth-regular",
+				"formats": ["woff"],
+				"style": "normal",
+				"weight": 400
+			},
+			{
+				"basename": "amaranth-bold",
+				"formats": ["woff"],
+				"style": "normal",
+				"weight": 400
+			}
+		]
+	}`
+	for _, dir := range []string{"a", "b"} {
+		family := filepath.Join(library, dir)
+		err = os.Mkdir(family, 0755)
+		test.VerifyFatal(t, 2, 0, true, nil == err)
+		for _, name := range []string{"amaranth-regular.woff", "amaranth-bold.woff"} {
+			data, err := ioutil.ReadFile(filepath.Join(amf, name))
+			test.VerifyFatal(t, 3, 0, true, nil == err)
+			err = ioutil.WriteFile(filepath.Join(family, name), data, 0644)
+			test.VerifyFatal(t, 4, 0, true, nil == err)
+		}
+		err = ioutil.WriteFile(filepath.Join(family, "metadata.json"),
+			[]byte(metadata), 0644)
+		test.VerifyFatal(t, 5, 0, true, nil == err)
+	}
+
+	inventory := New()
+	err = inventory.Build(library)
+	test.VerifyFatal(t, 6, 0, true, nil == err)
+	test.Verify(t, 7, 0, 1, inventory.Len())
+	test.Verify(t, 8, 0, 2, len(inventory.Errors))
+
+	// The first subfamily of the first directory is kept.
+	gFont := inventory.Query(Query{"Amaranth", "woff400normal"})
+	test.VerifyFatal(t, 9, 0, false, nil == gFont)
+	wPath := filepath.Join(library, "a", "amaranth-regular.woff")
+	test.Verify(t, 10, 0, wPath, gFont.Path)
+}
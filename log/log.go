@@ -0,0 +1,173 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+// Package log implements a leveled, structured logger writing text or JSON
+// records to standard error or to a file.
+package log
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Logger represents a leveled logger. Each record has a message and a list
+// of alternating field names and values. A nil *Logger discards all the
+// records, so it may be used when logging is disabled.
+type Logger struct {
+	file   *file
+	logger *slog.Logger
+}
+
+// file represents a log file which may be reopened, so that it can be rotated
+// while it is being written to.
+type file struct {
+	f    *os.File
+	mu   sync.Mutex
+	name string
+}
+
+func openFile(name string) (*file, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &file{f: f, name: name}, nil
+}
+
+func (f *file) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Write(b)
+}
+
+func (f *file) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
+
+// reopen closes and opens again the file, keeping the previous file if the
+// file cannot be opened.
+func (f *file) reopen() error {
+	nf, err := openFile(f.name)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.f.Close()
+	f.f = nf.f
+	return nil
+}
+
+// Debug logs a record at the debug level.
+func (l *Logger) Debug(msg string, args ...interface{}) {
+	if l != nil {
+		l.logger.Debug(msg, args...)
+	}
+}
+
+// Info logs a record at the info level.
+func (l *Logger) Info(msg string, args ...interface{}) {
+	if l != nil {
+		l.logger.Info(msg, args...)
+	}
+}
+
+// Warn logs a record at the warn level.
+func (l *Logger) Warn(msg string, args ...interface{}) {
+	if l != nil {
+		l.logger.Warn(msg, args...)
+	}
+}
+
+// Error logs a record at the error level.
+func (l *Logger) Error(msg string, args ...interface{}) {
+	if l != nil {
+		l.logger.Error(msg, args...)
+	}
+}
+
+// With returns a logger which adds the given fields to each record.
+func (l *Logger) With(args ...interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+	return &Logger{logger: l.logger.With(args...)}
+}
+
+// Close closes the file the logger writes to, if any.
+func (l *Logger) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Reopen closes and opens again the file the logger writes to, if any, so
+// that a rotated file is replaced by a new one. Returns an error if the file
+// cannot be opened, in which case the logger keeps writing to the previous
+// file.
+func (l *Logger) Reopen() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.reopen()
+}
+
+// New creates and returns a new logger writing the records of the given level
+// and above to w, using the given output format. The level is one of debug,
+// info, warn, or error, and the format is either text or json. Returns an
+// error if the level or the format is not valid.
+func New(w io.Writer, level, format string) (*Logger, error) {
+	var l slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		l = slog.LevelDebug
+	case "info":
+		l = slog.LevelInfo
+	case "warn":
+		l = slog.LevelWarn
+	case "error":
+		l = slog.LevelError
+	default:
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	options := &slog.HandlerOptions{Level: l}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+	return &Logger{logger: slog.New(handler)}, nil
+}
+
+// Open creates and returns a new logger, like New, writing to the named file,
+// which is created if needed and appended to, or to standard error if the name
+// is empty or "-". Returns an error if the file cannot be opened, or if the
+// level or the format is not valid.
+func Open(name, level, format string) (*Logger, error) {
+	if name == "" || name == "-" {
+		return New(os.Stderr, level, format)
+	}
+	f, err := openFile(name)
+	if err != nil {
+		return nil, err
+	}
+	l, err := New(f, level, format)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
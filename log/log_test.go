@@ -0,0 +1,127 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/noll/mjau/test"
+)
+
+func TestLoggerLevels(t *testing.T) {
+	var cases = []struct {
+		Level string
+		Lines int
+	}{
+		// Case 1
+		{"debug", 4},
+		// Case 2
+		{"info", 3},
+		// Case 3
+		{"WARN", 2},
+		// Case 4
+		{"error", 1},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		buf := new(bytes.Buffer)
+		l, err := New(buf, c.Level, "text")
+		test.VerifyFatal(t, 1, j, true, nil == err)
+		l.Debug("debug")
+		l.Info("info")
+		l.Warn("warn")
+		l.Error("error")
+		test.Verify(t, 2, j, c.Lines, strings.Count(buf.String(), "\n"))
+	}
+}
+
+func TestLoggerFormats(t *testing.T) {
+	var cases = []struct {
+		Format string
+		Record string
+	}{
+		// Case 1
+		{"text", `level=WARN msg="bad request" family=Amaranth status=400`},
+		// Case 2
+		{"json", `"level":"WARN","msg":"bad request","family":"Amaranth","status":400}`},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		buf := new(bytes.Buffer)
+		l, err := New(buf, "info", c.Format)
+		test.VerifyFatal(t, 1, j, true, nil == err)
+		l.With("family", "Amaranth").Warn("bad request", "status", 400)
+		test.Verify(t, 2, j, true, strings.Contains(buf.String(), c.Record))
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(ioutil.Discard, "verbose", "text")
+	test.Verify(t, 1, 0, false, nil == err)
+	_, err = New(ioutil.Discard, "info", "xml")
+	test.Verify(t, 2, 0, false, nil == err)
+
+	// A nil logger discards all the records.
+	var l *Logger
+	l.With("family", "Amaranth").Error("error")
+	test.Verify(t, 3, 0, true, nil == l.Close())
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "mjau.log")
+
+	for k := 0; k < 2; k++ {
+		l, err := Open(name, "info", "json")
+		test.VerifyFatal(t, 2, k, true, nil == err)
+		l.Info("started")
+		test.VerifyFatal(t, 3, k, true, nil == l.Close())
+	}
+	b, err := ioutil.ReadFile(name)
+	test.VerifyFatal(t, 4, 0, true, nil == err)
+	test.Verify(t, 5, 0, 2, strings.Count(string(b), `"msg":"started"`))
+
+	_, err = Open(dir, "info", "json")
+	test.Verify(t, 6, 0, false, nil == err)
+}
+
+func TestLoggerReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "mjau.log")
+	l, err := Open(name, "info", "text")
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+	defer l.Close()
+
+	l.Info("before")
+	// Rotate the log file.
+	rotated := name + ".1"
+	err = os.Rename(name, rotated)
+	test.VerifyFatal(t, 3, 0, true, nil == err)
+	test.VerifyFatal(t, 4, 0, true, nil == l.Reopen())
+	l.Info("after")
+
+	b, err := ioutil.ReadFile(rotated)
+	test.VerifyFatal(t, 5, 0, true, nil == err)
+	test.Verify(t, 6, 0, true, strings.Contains(string(b), "msg=before"))
+	test.Verify(t, 7, 0, false, strings.Contains(string(b), "msg=after"))
+	b, err = ioutil.ReadFile(name)
+	test.VerifyFatal(t, 8, 0, true, nil == err)
+	test.Verify(t, 9, 0, true, strings.Contains(string(b), "msg=after"))
+
+	// Loggers not writing to a file are not reopened.
+	var nl *Logger
+	test.Verify(t, 10, 0, true, nil == nl.Reopen())
+}
@@ -8,12 +8,18 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"text/template"
 
+	"github.com/noll/mjau/cache"
 	ihttp "github.com/noll/mjau/http" // Internal http package.
 	"github.com/noll/mjau/inventory"
+	"github.com/noll/mjau/log"
 	"github.com/noll/mjau/util"
 	"github.com/noll/mjau/whitelist"
 )
@@ -24,21 +30,41 @@ const (
 )
 
 var (
+	aFlag = flag.String("a", "", "path to access log file, - for stdout")
 	bFlag = flag.String("b", "0.0.0.0:80", "TCP address to bind to")
+	cFlag = flag.Uint64("c", 64, "font subsets cache size in MB, 0 disables it")
+	dFlag = flag.String("d", "info", "log level: debug, info, warn or error")
 	eFlag = flag.Bool("e", false, "toggle entity tags validation")
-	gFlag = flag.Bool("g", false, "toggle response gzip compression")
+	fFlag = flag.String("f", "-", "path to log file, - for stderr")
+	gFlag = flag.Bool("g", false, "toggle response compression")
+	iFlag = flag.String("i", "", "TCP address of admin endpoints, disabled if empty")
+	jFlag = flag.String("j", "text", "log format: text or json")
+	kFlag = flag.String("k", "combined", "access log format: common, combined or json")
 	lFlag = flag.String("l", "fonts/", "path to font library")
 	mFlag = flag.Uint64("m", 2592000, "Cache-Control max-age value")
+	nFlag = flag.Bool("n", false, "toggle nearest font matching")
 	oFlag = flag.Bool("o", false, "toggle cross-origin resource sharing")
+	pFlag = flag.Bool("p", false, "toggle precomputed CSS fragments, updated on reload only")
+	qFlag = flag.Duration("q", 0, "interval of resources change checks, 0 disables it")
+	rFlag = flag.Uint64("r", 32, "rendered CSS cache size in MB, 0 disables it")
+	sFlag = flag.Bool("s", false, "toggle strict font library validation")
 	tFlag = flag.String("t", "templates/", "path to templates directory")
+	uFlag = flag.String("u", "/font/", "base URL of linked font files")
 	vFlag = flag.Bool("v", false, "display version number and exit")
 	wFlag = flag.String("w", "whitelist.json", "path to whitelist file")
+	xFlag = flag.Bool("x", false, "toggle font files linking")
+	zFlag = flag.String("z", "", "comma-separated origins allowed by CORS, the whitelist if empty")
 )
 
 func init() {
 	util.BlankStrFlagDefault(bFlag, "b")
+	util.BlankStrFlagDefault(dFlag, "d")
+	util.BlankStrFlagDefault(fFlag, "f")
+	util.BlankStrFlagDefault(jFlag, "j")
+	util.BlankStrFlagDefault(kFlag, "k")
 	util.BlankStrFlagDefault(lFlag, "l")
 	util.BlankStrFlagDefault(tFlag, "t")
+	util.BlankStrFlagDefault(uFlag, "u")
 	util.BlankStrFlagDefault(wFlag, "w")
 	*lFlag = filepath.FromSlash(*lFlag)
 	*wFlag = filepath.FromSlash(*wFlag)
@@ -50,54 +76,249 @@ func main() {
 		fmt.Println(ProgName, ProgVersion)
 		os.Exit(0)
 	}
-	// Build font inventory.
-	fontInventory := inventory.New()
-	if err := fontInventory.Build(*lFlag); err != nil {
+	if flag.Arg(0) == "check" {
+		// Flags may also follow the check command.
+		flag.CommandLine.Parse(flag.Args()[1:])
+		Check()
+		return
+	}
+	// Open log.
+	logger, err := log.Open(*fFlag, *dFlag, *jFlag)
+	if err != nil {
 		PrintErrorExit(err.Error())
 	}
-	if fontInventory.Len() == 0 {
-		PrintErrorExit(fmt.Sprintf("%s: empty font library", *lFlag))
+	defer logger.Close()
+	// Open access log, if enabled.
+	var accessLog *ihttp.AccessLog
+	if *aFlag != "" {
+		accessLog, err = ihttp.OpenAccessLog(*aFlag, *kFlag)
+		if err != nil {
+			PrintErrorExit(err.Error())
+		}
+		defer accessLog.Close()
+	}
+	// Derive the path of the font handler from the
+	// base URL of linked font files.
+	fontPath, err := FontPath(*uFlag)
+	if err != nil {
+		PrintErrorExit(err.Error())
+	}
+	// Build font inventory.
+	fontInventory, err := BuildInventory(logger)
+	if err != nil {
+		PrintErrorExit(err.Error())
 	}
 	// Read whitelist.
-	whitelist := whitelist.New()
-	if err := whitelist.Read(*wFlag); err != nil {
+	whitelist, err := ReadWhitelist()
+	if err != nil {
 		PrintErrorExit(err.Error())
 	}
-	if whitelist.Size() == 0 {
-		PrintErrorExit(fmt.Sprintf("%s: empty whitelist", *wFlag))
-	}
-	// Parse templates.
-	templatesPath := filepath.FromSlash(*tFlag)
-	eot := filepath.Join(templatesPath, "eot.css.tmpl")
-	woff := filepath.Join(templatesPath, "woff.css.tmpl")
-	var templates *template.Template
-	var err error
-	if templates, err = template.ParseFiles(eot, woff); err != nil {
+	// Parse and check templates.
+	templates, err := LoadTemplates()
+	if err != nil {
 		PrintErrorExit(err.Error())
 	}
+	templatesModTime, err := util.ModTime(*tFlag)
+	if err != nil {
+		PrintErrorExit(err.Error())
+	}
+	// Create font subsets cache, shared by the CSS
+	// and font handlers.
+	var subsets *cache.Cache
+	if *cFlag > 0 {
+		subsets = cache.New(int64(*cFlag) << 20)
+	}
+	// Create rendered CSS cache.
+	var responses *cache.Cache
+	if *rFlag > 0 {
+		responses = cache.New(int64(*rFlag) << 20)
+	}
+	// Create reloadable resources.
+	resources := ihttp.NewResources(fontInventory, templates, templatesModTime,
+		whitelist)
 	// Create CSS handler function.
 	var cssHandler http.HandlerFunc
 	ctx := ihttp.HandlerContext{
 		Flags: ihttp.Flags{
 			AcAllowOrigin: *oFlag,
+			AcOrigins:     Origins(*zFlag),
 			CcMaxAge:      *mFlag,
+			Compress:      *gFlag,
 			Etag:          *eFlag,
-			Gzip:          *gFlag,
+			FontURL:       *uFlag,
+			Link:          *xFlag,
+			Nearest:       *nFlag,
 			Version:       ProgName + "/" + ProgVersion,
 		},
-		Inventory: *fontInventory,
-		Templates: *templates,
-		Whitelist: *whitelist,
+		Log:       logger,
+		Resources: resources,
+		Responses: responses,
+		Subsets:   subsets,
 	}
 	cssHandler = ihttp.MakeHandler(ihttp.CssHandler, ctx)
 	if *gFlag {
-		// Enable response gzip compression.
-		cssHandler = ihttp.MakeGzipHandler(cssHandler)
+		// Enable response compression.
+		cssHandler = ihttp.MakeCompressHandler(cssHandler)
+	}
+	// Create font handler function. Font files are
+	// already compressed, or served using range
+	// requests, so they are never compressed.
+	fontCtx := ctx
+	fontCtx.Flags.Compress = false
+	fontHandler := ihttp.MakeHandler(ihttp.FontHandler, fontCtx)
+	if accessLog != nil {
+		// Record the requests, including the size of
+		// the compressed response bodies.
+		cssHandler = ihttp.MakeAccessLogHandler(cssHandler, accessLog)
+		fontHandler = ihttp.MakeAccessLogHandler(fontHandler, accessLog)
+	}
+	if *pFlag {
+		// Precompute the CSS fragments of the fonts.
+		ctx.Resources.SetFragments(ihttp.NewFragments(fontInventory,
+			templates, *uFlag))
+	}
+	reloader := &Reloader{
+		Fragments: *pFlag,
+		Log:       logger,
+		Resources: ctx.Resources,
+		Responses: responses,
 	}
-	// Register CSS HTTP handler.
+	// Reopen the log files on SIGHUP, once they have
+	// been rotated, and reload the resources.
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := logger.Reopen(); err != nil {
+				logger.Error("log not reopened", "error", err)
+			}
+			if accessLog != nil {
+				if err := accessLog.Reopen(); err != nil {
+					logger.Error("access log not reopened", "error", err)
+				}
+			}
+			reloader.Reload()
+		}
+	}()
+	if *qFlag > 0 {
+		go reloader.Watch(*qFlag)
+	}
+	// Register CSS and font HTTP handlers.
 	http.HandleFunc("/css/", cssHandler)
+	http.HandleFunc(fontPath, fontHandler)
+	// Start admin HTTP server, if enabled. It is kept
+	// apart from the public server, so that it can be
+	// bound to a private address.
+	if *iFlag != "" {
+		admin := http.NewServeMux()
+		admin.Handle("/reload", reloader)
+		admin.Handle("/stats", StatsHandler(map[string]*cache.Cache{
+			"css":     responses,
+			"subsets": subsets,
+		}))
+		go func() {
+			logger.Info("admin server started", "address", *iFlag)
+			if err := http.ListenAndServe(*iFlag, admin); err != nil {
+				PrintErrorExit(err.Error())
+			}
+		}()
+	}
 	// Start HTTP server.
+	logger.Info("server started", "address", *bFlag, "version", ProgVersion)
 	if err := http.ListenAndServe(*bFlag, nil); err != nil {
 		PrintErrorExit(err.Error())
 	}
 }
+
+// BuildInventory builds the font inventory from the font library, logging its
+// invalid entries. Returns an error if the inventory cannot be built, or if
+// the font library is empty.
+func BuildInventory(logger *log.Logger) (*inventory.Inventory, error) {
+	fontInventory := inventory.New()
+	fontInventory.Strict = *sFlag
+	if err := fontInventory.Build(*lFlag); err != nil {
+		return nil, err
+	}
+	for _, err := range fontInventory.Errors {
+		logger.Warn("invalid font library entry", "error", err)
+	}
+	if fontInventory.Len() == 0 {
+		return nil, fmt.Errorf("%s: empty font library", *lFlag)
+	}
+	return fontInventory, nil
+}
+
+// ReadWhitelist reads the whitelist from the whitelist file. Returns an error
+// if the whitelist file cannot be read or parsed, or if the whitelist is
+// empty.
+func ReadWhitelist() (*whitelist.Whitelist, error) {
+	whitelist := whitelist.New()
+	if err := whitelist.Read(*wFlag); err != nil {
+		return nil, err
+	}
+	if whitelist.Size() == 0 {
+		return nil, fmt.Errorf("%s: empty whitelist", *wFlag)
+	}
+	return whitelist, nil
+}
+
+// Origins returns the origins of the given comma-separated list, ignoring the
+// blank ones and any trailing slash.
+func Origins(list string) []string {
+	var origins []string
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// LoadTemplates parses the templates from the templates directory and checks
+// them using sample data. Returns an error if any of the templates cannot be
+// read or parsed, or fails to execute.
+func LoadTemplates() (*template.Template, error) {
+	templates, err := ReadTemplates(*tFlag)
+	if err != nil {
+		return nil, err
+	}
+	if errs := ihttp.CheckTemplates(templates); len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %s", *tFlag, errs[0])
+	}
+	return templates, nil
+}
+
+// ReadTemplates parses the templates used by the CSS handler from the named
+// templates directory. Returns an error if any of the templates cannot be read
+// or parsed.
+func ReadTemplates(name string) (*template.Template, error) {
+	var filenames []string
+	for _, filename := range ihttp.TemplateNames() {
+		path := filepath.Join(filepath.FromSlash(name), filename)
+		filenames = append(filenames, path)
+	}
+	return template.ParseFiles(filenames...)
+}
+
+// FontPath returns the URL path the font handler is registered at, that is the
+// path of the given base URL of linked font files, so that the linked font
+// files are served wherever they are linked to. Returns an error if the path
+// is not absolute, does not end with a slash, or overlaps the path of the CSS
+// handler.
+func FontPath(fontURL string) (string, error) {
+	u, err := url.Parse(fontURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL of linked font files: %s", err)
+	}
+	p := u.Path
+	if !strings.HasPrefix(p, "/") || !strings.HasSuffix(p, "/") {
+		return "", fmt.Errorf("%s: base URL path must start and end with /",
+			fontURL)
+	}
+	if p == "/" || strings.HasPrefix(p, "/css/") {
+		return "", fmt.Errorf("%s: base URL path overlaps the CSS files",
+			fontURL)
+	}
+	return p, nil
+}
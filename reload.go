@@ -0,0 +1,198 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/noll/mjau/cache"
+	ihttp "github.com/noll/mjau/http" // Internal http package.
+	"github.com/noll/mjau/log"
+	"github.com/noll/mjau/util"
+)
+
+// Reloader reloads the resources of the handlers while the server is running,
+// on SIGHUP, on requests to the admin reload endpoint, or when a change of
+// their files is detected. Reloads are serialized, and a resource which fails
+// to reload is kept unchanged.
+type Reloader struct {
+	Fragments      bool // Precomputed CSS fragments toggle.
+	Log            *log.Logger
+	Resources      *ihttp.Resources
+	Responses      *cache.Cache // Rendered CSS cache, nil if disabled.
+	inventoryStamp string
+	mu             sync.Mutex
+	templatesStamp string
+	whitelistStamp string
+}
+
+// Reload reloads all the resources, even if some of them fail to reload.
+// Returns the first error encountered.
+func (rl *Reloader) Reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	err := rl.reloadInventory()
+	tErr := rl.reloadTemplates()
+	if err == nil || tErr == nil {
+		rl.refresh()
+	}
+	if err == nil {
+		err = tErr
+	}
+	if wErr := rl.reloadWhitelist(); err == nil {
+		err = wErr
+	}
+	return err
+}
+
+// reloadInventory rebuilds the font inventory from the font library and
+// replaces the current one, unless the new one cannot be built. The caller
+// must refresh the derived resources once the inventory is replaced.
+func (rl *Reloader) reloadInventory() error {
+	// Stamp the font library before building the inventory,
+	// so that changes made meanwhile are not missed.
+	stamp, _ := util.Stamp(*lFlag)
+	fontInventory, err := BuildInventory(rl.Log)
+	if err != nil {
+		rl.Log.Error("font library not reloaded", "path", *lFlag,
+			"error", err)
+		return err
+	}
+	rl.Resources.SetInventory(fontInventory)
+	rl.inventoryStamp = stamp
+	rl.Log.Info("font library reloaded", "path", *lFlag)
+	return nil
+}
+
+// reloadTemplates parses the templates again and replaces the current ones,
+// unless the new ones cannot be parsed or fail to execute using sample data.
+// The caller must refresh the derived resources once the templates are
+// replaced.
+func (rl *Reloader) reloadTemplates() error {
+	stamp, _ := util.Stamp(*tFlag)
+	templates, err := LoadTemplates()
+	var modtime time.Time
+	if err == nil {
+		modtime, err = util.ModTime(*tFlag)
+	}
+	if err != nil {
+		rl.Log.Error("templates not reloaded", "path", *tFlag, "error", err)
+		return err
+	}
+	rl.Resources.SetTemplates(templates, modtime)
+	rl.templatesStamp = stamp
+	rl.Log.Info("templates reloaded", "path", *tFlag)
+	return nil
+}
+
+// refresh precomputes the CSS fragments again and clears the rendered CSS
+// cache, if enabled, since they are stale once the font inventory or the
+// templates reload.
+func (rl *Reloader) refresh() {
+	if rl.Fragments {
+		rl.Resources.SetFragments(ihttp.NewFragments(rl.Resources.Inventory(),
+			rl.Resources.Templates(), *uFlag))
+	}
+	if rl.Responses != nil {
+		rl.Responses.Clear()
+	}
+}
+
+// reloadWhitelist reads the whitelist file again and replaces the current
+// whitelist, unless the new one is invalid or empty.
+func (rl *Reloader) reloadWhitelist() error {
+	stamp, _ := util.Stamp(*wFlag)
+	whitelist, err := ReadWhitelist()
+	if err != nil {
+		rl.Log.Error("whitelist not reloaded", "path", *wFlag, "error", err)
+		return err
+	}
+	rl.Resources.SetWhitelist(whitelist)
+	rl.whitelistStamp = stamp
+	rl.Log.Info("whitelist reloaded", "path", *wFlag)
+	return nil
+}
+
+// Watch checks the files of the resources for changes at the given interval
+// and reloads the changed resources. It never returns.
+func (rl *Reloader) Watch(interval time.Duration) {
+	rl.mu.Lock()
+	if rl.inventoryStamp == "" {
+		rl.inventoryStamp, _ = util.Stamp(*lFlag)
+	}
+	if rl.templatesStamp == "" {
+		rl.templatesStamp, _ = util.Stamp(*tFlag)
+	}
+	if rl.whitelistStamp == "" {
+		rl.whitelistStamp, _ = util.Stamp(*wFlag)
+	}
+	rl.mu.Unlock()
+	for range time.Tick(interval) {
+		rl.mu.Lock()
+		inventory := rl.watch("font library", *lFlag, &rl.inventoryStamp,
+			rl.reloadInventory)
+		templates := rl.watch("templates", *tFlag, &rl.templatesStamp,
+			rl.reloadTemplates)
+		if inventory || templates {
+			rl.refresh()
+		}
+		rl.watch("whitelist", *wFlag, &rl.whitelistStamp,
+			rl.reloadWhitelist)
+		rl.mu.Unlock()
+	}
+}
+
+// watch reloads the named resource using the given function if the stamp of
+// its files, found at the given path, differs from the given one. Returns
+// whether the resource was reloaded.
+func (rl *Reloader) watch(name, path string, stamp *string,
+	reload func() error) bool {
+	s, err := util.Stamp(path)
+	if err != nil {
+		rl.Log.Warn(name+" not watched", "path", path, "error", err)
+		return false
+	}
+	if s == *stamp {
+		return false
+	}
+	// Don't retry a failed reload until
+	// the files change again.
+	*stamp = s
+	return reload() == nil
+}
+
+// StatsHandler returns a handler reporting the statistics of the given caches,
+// identified by their names, as a JSON object. Disabled caches are omitted.
+func StatsHandler(caches map[string]*cache.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := make(map[string]cache.Stats)
+		for name, c := range caches {
+			if c != nil {
+				stats[name] = c.Stats()
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// ServeHTTP reloads all the resources on POST requests, and reports whether
+// they were reloaded.
+func (rl *Reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := rl.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "reloaded")
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2012, Robert Dinu. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license which can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/noll/mjau/cache"
+	ihttp "github.com/noll/mjau/http" // Internal http package.
+	"github.com/noll/mjau/log"
+	"github.com/noll/mjau/test"
+)
+
+func TestReloaderReload(t *testing.T) {
+	// Copy the Amaranth font family, the templates, and
+	// a whitelist, so that they can be broken.
+	dir, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(dir)
+	library := filepath.Join(dir, "fonts")
+	family := filepath.Join(library, "Amaranth")
+	templates := filepath.Join(dir, "templates")
+	copies := map[string]string{
+		filepath.Join("fonts", "Amaranth"): family,
+		"templates":                        templates,
+	}
+	for src, dst := range copies {
+		err = os.MkdirAll(dst, 0755)
+		test.VerifyFatal(t, 2, 0, true, nil == err)
+		entries, err := ioutil.ReadDir(src)
+		test.VerifyFatal(t, 3, 0, true, nil == err)
+		for _, entry := range entries {
+			data, err := ioutil.ReadFile(filepath.Join(src, entry.Name()))
+			test.VerifyFatal(t, 4, 0, true, nil == err)
+			err = ioutil.WriteFile(filepath.Join(dst, entry.Name()), data,
+				0644)
+			test.VerifyFatal(t, 5, 0, true, nil == err)
+		}
+	}
+	whitelistFile := filepath.Join(dir, "whitelist.json")
+	err = ioutil.WriteFile(whitelistFile,
+		[]byte(`{"domains": ["http://localhost/"]}`), 0644)
+	test.VerifyFatal(t, 6, 0, true, nil == err)
+	l, tpl, w := *lFlag, *tFlag, *wFlag
+	defer func() {
+		*lFlag, *tFlag, *wFlag = l, tpl, w
+	}()
+	*lFlag, *tFlag, *wFlag = library, templates, whitelistFile
+
+	logger, err := log.New(ioutil.Discard, "info", "text")
+	test.VerifyFatal(t, 7, 0, true, nil == err)
+	inv, err := BuildInventory(logger)
+	test.VerifyFatal(t, 8, 0, true, nil == err)
+	tmpl, err := LoadTemplates()
+	test.VerifyFatal(t, 9, 0, true, nil == err)
+	wl, err := ReadWhitelist()
+	test.VerifyFatal(t, 10, 0, true, nil == err)
+	responses := cache.New(1 << 20)
+	rl := &Reloader{
+		Fragments: true,
+		Log:       logger,
+		Resources: ihttp.NewResources(inv, tmpl, time.Time{}, wl),
+		Responses: responses,
+	}
+
+	// A successful reload replaces all the resources,
+	// and refreshes the derived ones.
+	responses.Put("css", []byte("stale"))
+	err = rl.Reload()
+	test.VerifyFatal(t, 11, 0, true, nil == err)
+	test.Verify(t, 12, 0, false, inv == rl.Resources.Inventory())
+	test.Verify(t, 13, 0, false, tmpl == rl.Resources.Templates())
+	test.Verify(t, 14, 0, false, wl == rl.Resources.Whitelist())
+	test.Verify(t, 15, 0, false, nil == rl.Resources.Fragments())
+	test.Verify(t, 16, 0, 0, responses.Len())
+
+	// A failed reload keeps all the previous resources,
+	// along with the derived ones.
+	inv = rl.Resources.Inventory()
+	tmpl = rl.Resources.Templates()
+	wl = rl.Resources.Whitelist()
+	fragments := rl.Resources.Fragments()
+	responses.Put("css", []byte("fresh"))
+	err = os.RemoveAll(family)
+	test.VerifyFatal(t, 17, 0, true, nil == err)
+	err = ioutil.WriteFile(filepath.Join(templates, "woff.css.tmpl"),
+		[]byte("{{"), 0644)
+	test.VerifyFatal(t, 18, 0, true, nil == err)
+	err = ioutil.WriteFile(whitelistFile, []byte("{"), 0644)
+	test.VerifyFatal(t, 19, 0, true, nil == err)
+	err = rl.Reload()
+	test.Verify(t, 20, 0, false, nil == err)
+	test.Verify(t, 21, 0, true, inv == rl.Resources.Inventory())
+	test.Verify(t, 22, 0, true, tmpl == rl.Resources.Templates())
+	test.Verify(t, 23, 0, true, wl == rl.Resources.Whitelist())
+	test.Verify(t, 24, 0, true, fragments == rl.Resources.Fragments())
+	test.Verify(t, 25, 0, 1, responses.Len())
+}
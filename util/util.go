@@ -7,12 +7,15 @@ package util
 
 import (
 	"bytes"
+	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 // Base64 returns the contents of b as a base64-encoded string.
@@ -66,8 +69,83 @@ func ReadJson(name string, v interface{}) error {
 		return err
 	} else {
 		if err := json.Unmarshal(b, &v); err != nil {
-			return fmt.Errorf("parse %s: %s", name, err)
+			// Locate syntax and type errors
+			// in the JSON-encoded contents.
+			var offset int64
+			switch e := err.(type) {
+			case *json.SyntaxError:
+				offset = e.Offset
+			case *json.UnmarshalTypeError:
+				offset = e.Offset
+			default:
+				return fmt.Errorf("parse %s: %s", name, err)
+			}
+			line, column := position(b, offset)
+			return fmt.Errorf("parse %s:%d:%d: %s", name, line, column, err)
 		}
 	}
 	return nil
 }
+
+// position returns the line and column numbers, starting at 1, of the byte
+// at the given offset in b.
+func position(b []byte, offset int64) (line, column int) {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	line, column = 1, 1
+	for _, c := range b[:offset] {
+		if c == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return
+}
+
+// Stamp returns a stamp of the named files and directories, including their
+// descendants, which changes whenever any of them is created, removed,
+// renamed, or modified. Returns an error if any of them cannot be read.
+func Stamp(names ...string) (string, error) {
+	hash := md5.New()
+	for _, name := range names {
+		err := filepath.Walk(name, func(path string, fi os.FileInfo,
+			err error) error {
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%s\x00%d\x00%d\x00", path, fi.Size(),
+				fi.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// ModTime returns the newest modification time of the named files and
+// directories, including their descendants. Returns an error if any of them
+// cannot be read.
+func ModTime(names ...string) (time.Time, error) {
+	var modtime time.Time
+	for _, name := range names {
+		err := filepath.Walk(name, func(path string, fi os.FileInfo,
+			err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.ModTime().After(modtime) {
+				modtime = fi.ModTime()
+			}
+			return nil
+		})
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return modtime, nil
+}
@@ -5,8 +5,11 @@
 package util
 
 import (
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/noll/mjau/test"
 )
@@ -53,3 +56,84 @@ func TestReadJson(t *testing.T) {
 	test.VerifyFatal(t, 1, 0, true, nil == err)
 	test.Verify(t, 2, 0, "json", testJson.Test)
 }
+
+func TestPosition(t *testing.T) {
+	b := []byte("{\n\t\"test\": 1\n}\n")
+	var cases = []struct {
+		Offset int64
+		Line   int
+		Column int
+	}{
+		// Case 1
+		{0, 1, 1},
+		// Case 2
+		{1, 1, 2},
+		// Case 3
+		{2, 2, 1},
+		// Case 4
+		{12, 2, 11},
+		// Case 5
+		{100, 4, 1},
+	}
+
+	for i, c := range cases {
+		j := i + 1
+		line, column := position(b, c.Offset)
+		test.Verify(t, 1, j, c.Line, line)
+		test.Verify(t, 2, j, c.Column, column)
+	}
+}
+
+func TestStamp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.file")
+
+	stamp := func() string {
+		s, err := Stamp(dir, ef)
+		test.VerifyFatal(t, 2, 0, true, nil == err)
+		return s
+	}
+	empty := stamp()
+	test.Verify(t, 3, 0, empty, stamp())
+	// Created file.
+	err = ioutil.WriteFile(name, []byte("test"), 0644)
+	test.VerifyFatal(t, 4, 0, true, nil == err)
+	created := stamp()
+	test.Verify(t, 5, 0, false, empty == created)
+	// Modified file.
+	modtime := time.Now().Add(time.Hour)
+	err = os.Chtimes(name, modtime, modtime)
+	test.VerifyFatal(t, 6, 0, true, nil == err)
+	modified := stamp()
+	test.Verify(t, 7, 0, false, created == modified)
+	// Removed file.
+	err = os.Remove(name)
+	test.VerifyFatal(t, 8, 0, true, nil == err)
+	test.Verify(t, 9, 0, false, modified == stamp())
+
+	_, err = Stamp(nf)
+	test.Verify(t, 10, 0, false, nil == err)
+}
+
+func TestModTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mjau")
+	test.VerifyFatal(t, 1, 0, true, nil == err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.file")
+	err = ioutil.WriteFile(name, []byte("test"), 0644)
+	test.VerifyFatal(t, 2, 0, true, nil == err)
+
+	// The newest modification time is the one
+	// of a file in the directory.
+	modtime := time.Now().Add(time.Hour).Truncate(time.Second)
+	err = os.Chtimes(name, modtime, modtime)
+	test.VerifyFatal(t, 3, 0, true, nil == err)
+	got, err := ModTime(ef, dir)
+	test.VerifyFatal(t, 4, 0, true, nil == err)
+	test.Verify(t, 5, 0, true, modtime.Equal(got))
+
+	_, err = ModTime(dir, nf)
+	test.Verify(t, 6, 0, false, nil == err)
+}